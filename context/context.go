@@ -0,0 +1,49 @@
+// Package context provides typed accessors for the request-scoped
+// values our middleware stores in a context.Context
+package context
+
+import (
+	"context"
+
+	"github.com/peterpla/webdevgo/models"
+)
+
+// privateKey is unexported so no other package can create
+// keys that collide with ours
+type privateKey string
+
+const (
+	userKey privateKey = "user"
+	csrfKey privateKey = "csrf"
+)
+
+// WithUser returns a copy of ctx that carries the provided user
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User returns the user stored in ctx by WithUser, or nil
+// if there is no user
+func User(ctx context.Context) *models.User {
+	if temp := ctx.Value(userKey); temp != nil {
+		if user, ok := temp.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// WithCSRFToken returns a copy of ctx that carries the provided
+// anti-forgery token, for forms to include
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfKey, token)
+}
+
+// CSRFToken returns the token stored in ctx by WithCSRFToken, or ""
+// if there is none
+func CSRFToken(ctx context.Context) string {
+	if token, ok := ctx.Value(csrfKey).(string); ok {
+		return token
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/views"
+)
+
+// lockoutsShownFor is how far back the lockouts page looks
+const lockoutsShownFor = 30 * 24 * time.Hour
+
+// Admin holds the views and services used by the administration pages
+type Admin struct {
+	LockoutsView *views.View
+	lts          models.LoginThrottleService
+}
+
+// NewAdmin returns an Admin controller
+func NewAdmin(lts models.LoginThrottleService) *Admin {
+	return &Admin{
+		LockoutsView: views.NewView("bootstrap", "admin/lockouts"),
+		lts:          lts,
+	}
+}
+
+// Lockouts lists the email addresses and IPs recently locked out
+// after too many failed logins, so administrators can see who is
+// being targeted
+//
+// GET /admin/lockouts
+func (a *Admin) Lockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := a.lts.Lockouts(time.Now().Add(-lockoutsShownFor))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = lockouts
+	a.LockoutsView.Render(w, r, vd)
+}
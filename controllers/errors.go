@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/peterpla/webdevgo/views"
+)
+
+// Errors holds the views for error pages rendered outside of any
+// other controller, e.g. by middleware
+type Errors struct {
+	CSRFView *views.View
+}
+
+// NewErrors returns an Errors controller
+func NewErrors() *Errors {
+	return &Errors{
+		CSRFView: views.NewView("bootstrap", "errors/csrf"),
+	}
+}
+
+// CSRF explains that a form submission was refused because it didn't
+// carry a valid anti-forgery token, most often because the form was
+// opened before the user's cookies were cleared
+func (e *Errors) CSRF(w http.ResponseWriter, r *http.Request) {
+	e.CSRFView.RenderStatus(w, r, http.StatusForbidden, nil)
+}
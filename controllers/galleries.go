@@ -1,27 +1,460 @@
 package controllers
 
 import (
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/gorilla/mux"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/storage"
 	"github.com/peterpla/webdevgo/views"
 )
 
-// Galleries ... [add documentation]
+// Named routes, used to build gallery URLs for redirects
+const (
+	ShowGallery  = "show_gallery"
+	EditGallery  = "edit_gallery"
+	IndexGallery = "index_gallery"
+)
+
+// maxMultipartMem is how much of an upload is held in memory; the
+// rest is spooled to temporary files
+const maxMultipartMem = 1 << 20 // 1 MB
+
+// Galleries holds the views and services used by the gallery handlers
 type Galleries struct {
-	Gallery *views.View
+	NewView   *views.View
+	ShowView  *views.View
+	EditView  *views.View
+	IndexView *views.View
+	ImageView *views.View
+	gs        models.GalleryService
+	is        models.ImageService
+	r         *mux.Router
 }
 
-// NewGalleries ... [add documentation]
-func NewGalleries() *Galleries {
+// NewGalleries returns a Galleries controller. The router is used
+// to build URLs for the named gallery routes.
+func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router) *Galleries {
 	return &Galleries{
-		Gallery: views.NewView("bootstrap", "galleries/gallery"),
+		NewView:   views.NewView("bootstrap", "galleries/new"),
+		ShowView:  views.NewView("bootstrap", "galleries/show"),
+		EditView:  views.NewView("bootstrap", "galleries/edit"),
+		IndexView: views.NewView("bootstrap", "galleries/index"),
+		ImageView: views.NewView("bootstrap", "galleries/image"),
+		gs:        gs,
+		is:        is,
+		r:         r,
 	}
 }
 
-// Create is used to render the form where a user can
-// create a new gallery
+// ImagePage is what the image detail page displays. Meta is nil
+// for images without EXIF metadata.
+type ImagePage struct {
+	Gallery *models.Gallery
+	Image   *models.Image
+	Meta    *models.ImageMeta
+}
+
+// GalleryForm holds the fields submitted by the new and edit gallery forms
+type GalleryForm struct {
+	Title string `schema:"title"`
+}
+
+// Index lists the galleries owned by the signed-in user
 //
-// POST /signup
+// GET /galleries
+func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	galleries, err := g.gs.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = galleries
+	g.IndexView.Render(w, r, vd)
+}
+
+// Create is used to process the new gallery form
+//
+// POST /galleries/new
 func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
-	g.Gallery.Render(w, nil)
+	user := context.User(r.Context())
+
+	var vd views.Data
+	var form GalleryForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.NewView.Render(w, r, vd)
+		return
+	}
+
+	gallery := models.Gallery{
+		Title:  form.Title,
+		UserID: user.ID,
+	}
+	if err := g.gs.Create(&gallery); err != nil {
+		vd.SetAlert(err)
+		vd.Yield = form
+		g.NewView.Render(w, r, vd)
+		return
+	}
+
+	g.redirectToEdit(w, r, &gallery)
+}
+
+// Show displays a single gallery
+//
+// GET /galleries/{id}
+func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		// galleryByID has already rendered the error
+		return
+	}
+	if !g.loadImages(w, gallery) {
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = gallery
+	g.ShowView.Render(w, r, vd)
+}
+
+// Edit renders the form where the owner can edit a gallery
+//
+// GET /galleries/{id}/edit
+func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
+	gallery := g.ownedGallery(w, r)
+	if gallery == nil {
+		return
+	}
+	if !g.loadImages(w, gallery) {
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = gallery
+	g.EditView.Render(w, r, vd)
+}
+
+// Update is used to process the edit gallery form
+//
+// POST /galleries/{id}/edit
+func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
+	gallery := g.ownedGallery(w, r)
+	if gallery == nil {
+		return
+	}
+	if !g.loadImages(w, gallery) {
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = gallery
+	var form GalleryForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+
+	gallery.Title = form.Title
+	if err := g.gs.Update(gallery); err != nil {
+		vd.SetAlert(err)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Gallery successfully updated!",
+	}
+	g.EditView.Render(w, r, vd)
+}
+
+// Delete removes a gallery owned by the signed-in user
+//
+// POST /galleries/{id}/delete
+func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
+	gallery := g.ownedGallery(w, r)
+	if gallery == nil {
+		return
+	}
+
+	// remove the images first, so a failure leaves the gallery in
+	// place for the owner to try again
+	err := g.is.DeleteByGalleryID(gallery.ID)
+	if err == nil {
+		err = g.gs.Delete(gallery.ID)
+	}
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+		vd.Yield = gallery
+		g.EditView.Render(w, r, vd)
+		return
+	}
+
+	url, err := g.r.Get(IndexGallery).URL()
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// ImageUpload is used to process the upload images form. Several
+// images may be uploaded at once; they are added to the gallery in
+// the order they were selected.
+//
+// POST /galleries/{id}/images
+func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
+	gallery := g.ownedGallery(w, r)
+	if gallery == nil {
+		return
+	}
+
+	// the CSRF middleware has usually capped the body already, but
+	// not when the token came in a header
+	r.Body = http.MaxBytesReader(w, r.Body, models.ImageUploadMaxBytes)
+
+	var vd views.Data
+	vd.Yield = gallery
+	err := r.ParseMultipartForm(maxMultipartMem)
+	if err == nil {
+		err = g.saveImages(gallery, r)
+	}
+	if err != nil {
+		vd.SetAlert(err)
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+		g.EditView.Render(w, r, vd)
+		return
+	}
+
+	g.redirectToEdit(w, r, gallery)
+}
+
+// saveImages stores each file in the request's "images" field,
+// stopping at the first that can't be stored
+func (g *Galleries) saveImages(gallery *models.Gallery, r *http.Request) error {
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		return models.ErrImageRequired
+	}
+	if len(files) > models.ImageMaxUploads {
+		return models.ErrTooManyImages
+	}
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		image := models.Image{
+			GalleryID: gallery.ID,
+			Filename:  fh.Filename,
+		}
+		err = g.is.Create(&image, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImageShow displays a single image, with the camera details read
+// from its EXIF metadata
+//
+// GET /galleries/{id}/images/{imageID}
+func (g *Galleries) ImageShow(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		// galleryByID has already rendered the error
+		return
+	}
+	image := g.galleryImage(w, r)
+	if image == nil {
+		return
+	}
+
+	meta, err := g.is.Meta(image)
+	if err != nil && err != models.ErrNotFound {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = ImagePage{
+		Gallery: gallery,
+		Image:   image,
+		Meta:    meta,
+	}
+	g.ImageView.Render(w, r, vd)
+}
+
+// Image serves an image's file, or one of its resized variants
+//
+// GET /galleries/{id}/images/{imageID}/{filename}
+// GET /galleries/{id}/images/{imageID}/{variant}/{filename}
+func (g *Galleries) Image(w http.ResponseWriter, r *http.Request) {
+	image := g.galleryImage(w, r)
+	if image == nil {
+		return
+	}
+
+	variant := mux.Vars(r)["variant"]
+	f, err := g.is.Open(image, variant)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			log.Println(err)
+		}
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", image.VariantContentType(variant))
+	if variant == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(image.Size, 10))
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println(err)
+	}
+}
+
+// ImageDelete removes an image from a gallery owned by the
+// signed-in user
+//
+// POST /galleries/{id}/images/{imageID}/delete
+func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
+	gallery := g.ownedGallery(w, r)
+	if gallery == nil {
+		return
+	}
+	image := g.galleryImage(w, r)
+	if image == nil {
+		return
+	}
+
+	if err := g.is.Delete(image); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		gallery.Images, _ = g.is.ByGalleryID(gallery.ID)
+		vd.Yield = gallery
+		g.EditView.Render(w, r, vd)
+		return
+	}
+
+	g.redirectToEdit(w, r, gallery)
+}
+
+// redirectToEdit redirects to the gallery's edit page
+func (g *Galleries) redirectToEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) {
+	url, err := g.r.Get(EditGallery).URL("id", strconv.Itoa(int(gallery.ID)))
+	if err != nil {
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// loadImages fills in the gallery's images. On failure, an error
+// response has already been written and false is returned.
+func (g *Galleries) loadImages(w http.ResponseWriter, gallery *models.Gallery) bool {
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return false
+	}
+	gallery.Images = images
+	return true
+}
+
+// galleryImage looks up the image from the route variables and
+// confirms it belongs to the gallery in the route. On failure, an
+// appropriate response has already been written and nil is returned.
+func (g *Galleries) galleryImage(w http.ResponseWriter, r *http.Request) *models.Image {
+	vars := mux.Vars(r)
+	galleryID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return nil
+	}
+	imageID, err := strconv.Atoi(vars["imageID"])
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusNotFound)
+		return nil
+	}
+
+	image, err := g.is.ByID(uint(imageID))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil
+	}
+	if image.GalleryID != uint(galleryID) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return nil
+	}
+	return image
+}
+
+// galleryByID parses the gallery ID from the route variables and looks
+// up the gallery. On error, an appropriate response has already been
+// written and the caller should simply return.
+func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return nil, err
+	}
+
+	gallery, err := g.gs.ByID(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// ownedGallery looks up the gallery from the route variables and
+// confirms the signed-in user owns it. On failure, an appropriate
+// response has already been written and nil is returned.
+func (g *Galleries) ownedGallery(w http.ResponseWriter, r *http.Request) *models.Gallery {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return nil
+	}
+
+	user := context.User(r.Context())
+	if user == nil || gallery.UserID != user.ID {
+		http.Error(w, "You do not have permission to modify this gallery", http.StatusForbidden)
+		return nil
+	}
+	return gallery
 }
@@ -1,20 +1,80 @@
 package controllers
 
 import (
+	"log"
+	"net"
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/schema"
+
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/models"
 )
 
 func parseForm(r *http.Request, dest interface{}) error {
 	if err := r.ParseForm(); err != nil {
 		return err
 	}
+	return parseValues(r.PostForm, dest)
+}
+
+// parseURLParams decodes the request's URL query parameters into dest
+func parseURLParams(r *http.Request, dest interface{}) error {
+	return parseValues(r.URL.Query(), dest)
+}
 
+func parseValues(values url.Values, dest interface{}) error {
 	dec := schema.NewDecoder()
-	if err := dec.Decode(dest, r.PostForm); err != nil {
+	dec.IgnoreUnknownKeys(true)
+	if err := dec.Decode(dest, values); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// clientIP returns the IP address of the client making the request,
+// without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// currentSession returns the session identified by the requesting
+// device's remember_token cookie
+func currentSession(ss models.SessionService, cookies cookie.Policy, r *http.Request) (*models.Session, error) {
+	token, err := cookies.Get(r, cookie.Remember)
+	if err != nil {
+		return nil, err
+	}
+	return ss.ByToken(token)
+}
+
+// confirmPassword checks the signed-in user's current password before
+// a sensitive change, through the same throttle as logging in, so a
+// stolen session can't be used to guess it. A wrong password counts
+// towards the email address's and IP's lockouts.
+func confirmPassword(us models.UserService, lts models.LoginThrottleService,
+	email, password string, r *http.Request) (*models.User, error) {
+	ip := clientIP(r)
+	if err := lts.Allow(email, ip); err != nil {
+		return nil, err
+	}
+
+	user, err := us.Authenticate(email, password)
+	switch err {
+	case nil:
+		if err := lts.Succeeded(email, ip); err != nil {
+			log.Println(err)
+		}
+	case models.ErrPasswordIncorrect:
+		if err := lts.Failed(email, ip); err != nil {
+			log.Println(err)
+		}
+	}
+	return user, err
+}
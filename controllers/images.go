@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/peterpla/webdevgo/diskcache"
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/imaging"
+	"github.com/peterpla/webdevgo/models"
+)
+
+// maxTransformSize is the largest width or height an image can be
+// transformed to
+const maxTransformSize = 4000
+
+// transformCacheControl lets browsers and proxies keep a transformed
+// image forever; its URL changes whenever the result would
+const transformCacheControl = "public, max-age=31536000, immutable"
+
+// transformFormats maps the content types of uploaded images to the
+// format they're transformed to when none is requested
+var transformFormats = map[string]string{
+	"image/jpeg": imaging.FormatJPEG,
+	"image/png":  imaging.FormatPNG,
+	"image/gif":  imaging.FormatGIF,
+}
+
+// Images serves gallery images transformed on demand. Only URLs made
+// by URL are served, so the parameters can't be varied to make the
+// server do unbounded work.
+type Images struct {
+	is    models.ImageService
+	hmac  hash.HMAC
+	cache *diskcache.Cache
+}
+
+// NewImages returns an Images controller. URLs are signed with hmac,
+// and the transformed images are kept in cache.
+func NewImages(is models.ImageService, hmac hash.HMAC, cache *diskcache.Cache) *Images {
+	return &Images{
+		is:    is,
+		hmac:  hmac,
+		cache: cache,
+	}
+}
+
+// TransformForm holds the query parameters of a transformed image's URL
+type TransformForm struct {
+	Width     int    `schema:"w"`
+	Height    int    `schema:"h"`
+	Fit       string `schema:"fit"`
+	Format    string `schema:"fmt"`
+	Signature string `schema:"s"`
+}
+
+// normalize fills in the defaults, and reports whether the form asks
+// for a transformation that can be made
+func (form *TransformForm) normalize() bool {
+	if form.Fit == "" {
+		form.Fit = imaging.FitContain
+	}
+	if form.Width < 0 || form.Width > maxTransformSize ||
+		form.Height < 0 || form.Height > maxTransformSize {
+		return false
+	}
+	switch form.Fit {
+	case imaging.FitContain:
+	case imaging.FitCover:
+		if form.Width == 0 || form.Height == 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	switch form.Format {
+	case "", imaging.FormatJPEG, imaging.FormatPNG, imaging.FormatGIF:
+		return true
+	default:
+		return false
+	}
+}
+
+// signed returns the string signed for a transformation of image,
+// which covers every parameter
+func (form *TransformForm) signed(image *models.Image) string {
+	return fmt.Sprintf("img:%d/%d?w=%d&h=%d&fit=%s&fmt=%s",
+		image.GalleryID, image.ID, form.Width, form.Height, form.Fit, form.Format)
+}
+
+// URL returns the signed URL of image transformed to fit a width x
+// height box, in the given way. A width or height of zero leaves
+// that dimension free, and an empty format keeps the image's own.
+func (i *Images) URL(image *models.Image, width, height int, fit, format string) string {
+	form := TransformForm{
+		Width:  width,
+		Height: height,
+		Fit:    fit,
+		Format: format,
+	}
+	form.normalize()
+
+	v := url.Values{}
+	if form.Width > 0 {
+		v.Set("w", strconv.Itoa(form.Width))
+	}
+	if form.Height > 0 {
+		v.Set("h", strconv.Itoa(form.Height))
+	}
+	v.Set("fit", form.Fit)
+	if form.Format != "" {
+		v.Set("fmt", form.Format)
+	}
+	v.Set("s", i.hmac.Hash(form.signed(image)))
+	return fmt.Sprintf("/img/%d/%d?%s", image.GalleryID, image.ID, v.Encode())
+}
+
+// Transform serves an image resized, cropped or converted as its
+// signed URL asks, from the cache when it can
+//
+// GET /img/{galleryID}/{imageID}?w=&h=&fit=&fmt=&s=
+func (i *Images) Transform(w http.ResponseWriter, r *http.Request) {
+	image := i.image(w, r)
+	if image == nil {
+		return
+	}
+
+	var form TransformForm
+	if err := parseURLParams(r, &form); err != nil || !form.normalize() {
+		http.Error(w, "Invalid image parameters", http.StatusBadRequest)
+		return
+	}
+	if !i.hmac.Verify(form.signed(image), form.Signature) {
+		http.Error(w, "Invalid image signature", http.StatusForbidden)
+		return
+	}
+	format := form.Format
+	if format == "" {
+		format = transformFormats[image.ContentType]
+	}
+
+	// the key changes if the image is replaced, so cached results
+	// and ETags never outlive the image they were made from
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d/%d/%d?w=%d&h=%d&fit=%s&fmt=%s",
+		image.ID, image.CreatedAt.UnixNano(), image.Size,
+		form.Width, form.Height, form.Fit, format)))
+	key := hex.EncodeToString(sum[:])
+	etag := `"` + key + `"`
+
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", transformCacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, ok := i.cache.Get(key)
+	if !ok {
+		var err error
+		data, err = i.transform(image, &form, format)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+		if err := i.cache.Put(key, data); err != nil {
+			log.Println(err)
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", transformCacheControl)
+	w.Header().Set("Content-Type", "image/"+format)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(data)
+}
+
+// transform decodes the image's original file, and returns it
+// transformed as the form asks, encoded in format
+func (i *Images) transform(img *models.Image, form *TransformForm, format string) ([]byte, error) {
+	f, err := i.is.Open(img, "")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	dst := imaging.Transform(src, form.Width, form.Height, form.Fit)
+	if err := imaging.Encode(&buf, dst, format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// image looks up the image from the route variables and confirms it
+// belongs to the gallery in the route. On failure, an appropriate
+// response has already been written and nil is returned.
+func (i *Images) image(w http.ResponseWriter, r *http.Request) *models.Image {
+	vars := mux.Vars(r)
+	galleryID, err := strconv.Atoi(vars["galleryID"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return nil
+	}
+	imageID, err := strconv.Atoi(vars["imageID"])
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusNotFound)
+		return nil
+	}
+
+	image, err := i.is.ByID(uint(imageID))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Image not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil
+	}
+	if image.GalleryID != uint(galleryID) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return nil
+	}
+	return image
+}
+
+// matchesETag reports whether an If-None-Match header lists etag
+func matchesETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
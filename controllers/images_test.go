@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/peterpla/webdevgo/diskcache"
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/models"
+)
+
+// fakeImageService serves a single PNG image, counting how often its
+// file is opened
+type fakeImageService struct {
+	models.ImageService
+	image *models.Image
+	data  []byte
+	opens int
+}
+
+func (is *fakeImageService) ByID(id uint) (*models.Image, error) {
+	if id != is.image.ID {
+		return nil, models.ErrNotFound
+	}
+	return is.image, nil
+}
+
+func (is *fakeImageService) Open(image *models.Image, variant string) (io.ReadCloser, error) {
+	is.opens++
+	return ioutil.NopCloser(bytes.NewReader(is.data)), nil
+}
+
+func TestImagesTransform(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatalf("png.Encode(): expected nil, got \"%v\"", err)
+	}
+	is := &fakeImageService{
+		image: &models.Image{GalleryID: 3, ContentType: "image/png", Size: int64(buf.Len())},
+		data:  buf.Bytes(),
+	}
+	is.image.ID = 7
+	is.image.CreatedAt = time.Now()
+
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatalf("TempDir(): expected nil, got \"%v\"", err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := diskcache.New(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("diskcache.New(): expected nil, got \"%v\"", err)
+	}
+
+	c := NewImages(is, hash.NewHMAC("secret-hmac-key"), cache)
+	other := NewImages(is, hash.NewHMAC("some-other-key"), cache)
+	r := mux.NewRouter()
+	r.HandleFunc("/img/{galleryID:[0-9]+}/{imageID:[0-9]+}", c.Transform)
+
+	get := func(url, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	url := c.URL(is.image, 10, 10, "cover", "")
+	rr := get(url, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Transform(): expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Transform(): expected Content-Type \"image/png\", got \"%s\"", ct)
+	}
+	got, err := png.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("png.Decode(): expected nil, got \"%v\"", err)
+	}
+	if b := got.Bounds(); b.Dx() != 10 || b.Dy() != 10 {
+		t.Errorf("Transform(): expected a 10x10 image, got %dx%d", b.Dx(), b.Dy())
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Errorf("Transform(): expected an ETag")
+	}
+
+	// the second request is served from the cache
+	rr = get(url, "")
+	if rr.Code != http.StatusOK || is.opens != 1 {
+		t.Errorf("Transform() again: expected status %d and 1 open, got %d and %d",
+			http.StatusOK, rr.Code, is.opens)
+	}
+
+	// a matching ETag is answered without a body
+	rr = get(url, `"other", `+etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Transform() with ETag: expected status %d and no body, got %d and %d bytes",
+			http.StatusNotModified, rr.Code, rr.Body.Len())
+	}
+	if rr = get(url, `"other"`); rr.Code != http.StatusOK {
+		t.Errorf("Transform() with other ETag: expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	type testset struct {
+		name    string
+		url     string
+		expCode int
+	}
+
+	var tests = []testset{
+		{"changed width", strings.Replace(url, "w=10", "w=11", 1), http.StatusForbidden},
+		{"changed fit", strings.Replace(url, "fit=cover", "fit=contain", 1), http.StatusForbidden},
+		{"added format", url + "&fmt=gif", http.StatusForbidden},
+		{"no signature", "/img/3/7?w=10&h=10&fit=cover", http.StatusForbidden},
+		{"other key", other.URL(is.image, 10, 10, "cover", ""), http.StatusForbidden},
+		{"other gallery", strings.Replace(url, "/img/3/", "/img/4/", 1), http.StatusNotFound},
+		{"unknown image", strings.Replace(url, "/img/3/7", "/img/3/8", 1), http.StatusNotFound},
+		{"too large", c.URL(is.image, maxTransformSize+1, 10, "", ""), http.StatusBadRequest},
+	}
+
+	opens := is.opens
+	for _, tt := range tests {
+		if rr := get(tt.url, ""); rr.Code != tt.expCode {
+			t.Errorf("Transform(%s): expected status %d, got %d", tt.name, tt.expCode, rr.Code)
+		}
+	}
+	if is.opens != opens {
+		t.Errorf("Transform(): expected rejected requests not to open the image")
+	}
+}
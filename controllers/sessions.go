@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/views"
+)
+
+// Sessions holds the views and services used to manage the
+// signed-in user's devices
+type Sessions struct {
+	IndexView *views.View
+	ss        models.SessionService
+	cookies   cookie.Policy
+}
+
+// NewSessions returns a Sessions controller
+func NewSessions(ss models.SessionService, cookies cookie.Policy) *Sessions {
+	return &Sessions{
+		IndexView: views.NewView("bootstrap", "sessions/index"),
+		ss:        ss,
+		cookies:   cookies,
+	}
+}
+
+// sessionRow is a single device listed on the sessions page
+type sessionRow struct {
+	models.Session
+	Current bool
+}
+
+// Index lists every device the signed-in user is signed in on,
+// marking the device making the request
+//
+// GET /account/sessions
+func (s *Sessions) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	sessions, err := s.ss.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var currentID uint
+	if current, err := currentSession(s.ss, s.cookies, r); err == nil {
+		currentID = current.ID
+	}
+
+	rows := make([]sessionRow, len(sessions))
+	for i, session := range sessions {
+		rows[i] = sessionRow{
+			Session: session,
+			Current: session.ID == currentID,
+		}
+	}
+
+	var vd views.Data
+	vd.Yield = rows
+	s.IndexView.Render(w, r, vd)
+}
+
+// Revoke signs out a single device belonging to the signed-in user
+//
+// POST /account/sessions/{id}/revoke
+func (s *Sessions) Revoke(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusNotFound)
+		return
+	}
+
+	session, err := s.ss.ByID(uint(id))
+	if err != nil || session.UserID != user.ID {
+		// don't reveal whether another user's session exists
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.ss.Delete(session.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/sessions", http.StatusFound)
+}
+
+// RevokeOthers signs out every device belonging to the signed-in
+// user except the one making the request
+//
+// POST /account/sessions/revoke-others
+func (s *Sessions) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	current, err := currentSession(s.ss, s.cookies, r)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.ss.DeleteOthers(user.ID, current.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/sessions", http.StatusFound)
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/views"
+)
+
+// TwoFactor holds the views and services used to manage the signed-in
+// user's two-factor authentication from the account page
+type TwoFactor struct {
+	EnrollView        *views.View
+	RecoveryCodesView *views.View
+	us                models.UserService
+	tfs               models.TwoFactorService
+	lts               models.LoginThrottleService
+}
+
+// NewTwoFactor returns a TwoFactor controller. Password confirmations
+// are throttled by lts, like logins.
+func NewTwoFactor(us models.UserService, tfs models.TwoFactorService, lts models.LoginThrottleService) *TwoFactor {
+	return &TwoFactor{
+		EnrollView:        views.NewView("bootstrap", "two_factor/enroll"),
+		RecoveryCodesView: views.NewView("bootstrap", "two_factor/recovery_codes"),
+		us:                us,
+		tfs:               tfs,
+		lts:               lts,
+	}
+}
+
+// enrollData is the view data for the enrollment page. URI is an
+// otpauth:// link, which html/template would otherwise refuse to use
+// as an href.
+type enrollData struct {
+	Secret string
+	URI    template.URL
+}
+
+// ConfirmPasswordForm is used where the user must confirm their
+// current password before making a change
+type ConfirmPasswordForm struct {
+	Password string `schema:"password"`
+}
+
+// ConfirmTwoFactorForm is used to turn on two-factor authentication
+// with a code from the user's app and their current password
+type ConfirmTwoFactorForm struct {
+	Code     string `schema:"code"`
+	Password string `schema:"password"`
+}
+
+// Enroll generates a new secret after the user confirms their current
+// password, and shows it, along with a form to confirm the user's
+// authenticator app is producing valid codes
+//
+// POST /account/2fa/enroll
+func (tf *TwoFactor) Enroll(w http.ResponseWriter, r *http.Request) {
+	user := tf.confirmPassword(w, r)
+	if user == nil {
+		return
+	}
+	if user.TwoFactorEnabled() {
+		http.Redirect(w, r, "/account", http.StatusFound)
+		return
+	}
+
+	var vd views.Data
+	if err := tf.tfs.BeginEnrollment(user); err != nil {
+		vd.SetAlert(err)
+		tf.EnrollView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = tf.newEnrollData(user)
+	tf.EnrollView.Render(w, r, vd)
+}
+
+// Confirm enables two-factor authentication once the user enters a
+// valid code and their current password, and shows their recovery
+// codes
+//
+// POST /account/2fa/confirm
+func (tf *TwoFactor) Confirm(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user.TwoFactorEnabled() {
+		http.Redirect(w, r, "/account", http.StatusFound)
+		return
+	}
+
+	var vd views.Data
+	vd.Yield = tf.newEnrollData(user)
+	var form ConfirmTwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		tf.EnrollView.Render(w, r, vd)
+		return
+	}
+	user, err := confirmPassword(tf.us, tf.lts, user.Email, form.Password, r)
+	if err != nil {
+		vd.SetAlert(err)
+		tf.EnrollView.Render(w, r, vd)
+		return
+	}
+
+	codes, err := tf.tfs.ConfirmEnrollment(user, form.Code)
+	if err != nil {
+		vd.SetAlert(err)
+		tf.EnrollView.Render(w, r, vd)
+		return
+	}
+
+	vd.Yield = codes
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two-factor authentication is now enabled.",
+	}
+	tf.RecoveryCodesView.Render(w, r, vd)
+}
+
+// RecoveryCodes replaces the user's recovery codes after they
+// confirm their current password
+//
+// POST /account/2fa/recovery-codes
+func (tf *TwoFactor) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := tf.confirmPassword(w, r)
+	if user == nil {
+		return
+	}
+
+	var vd views.Data
+	codes, err := tf.tfs.RegenerateRecoveryCodes(user)
+	if err != nil {
+		vd.SetAlert(err)
+		tf.RecoveryCodesView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = codes
+	tf.RecoveryCodesView.Render(w, r, vd)
+}
+
+// Disable turns off two-factor authentication after the user
+// confirms their current password
+//
+// POST /account/2fa/disable
+func (tf *TwoFactor) Disable(w http.ResponseWriter, r *http.Request) {
+	user := tf.confirmPassword(w, r)
+	if user == nil {
+		return
+	}
+
+	if err := tf.tfs.Disable(user); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		tf.RecoveryCodesView.Render(w, r, vd)
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusFound)
+}
+
+// confirmPassword checks the submitted current password for the
+// signed-in user. On failure, an error page has already been
+// written and nil is returned.
+func (tf *TwoFactor) confirmPassword(w http.ResponseWriter, r *http.Request) *models.User {
+	var form ConfirmPasswordForm
+	if err := parseForm(r, &form); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return nil
+	}
+
+	user, err := confirmPassword(tf.us, tf.lts, context.User(r.Context()).Email, form.Password, r)
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		tf.RecoveryCodesView.Render(w, r, vd)
+		return nil
+	}
+	return user
+}
+
+// newEnrollData returns the enrollment page data for the user's
+// pending secret
+func (tf *TwoFactor) newEnrollData(user *models.User) *enrollData {
+	return &enrollData{
+		Secret: user.TOTPSecret,
+		// made by the server from the user's own secret, so safe
+		URI: template.URL(tf.tfs.ProvisioningURI(user)),
+	}
+}
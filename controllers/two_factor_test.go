@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/models"
+)
+
+func TestTwoFactorEnroll(t *testing.T) {
+	user := &models.User{Email: "bozo@clown.net"}
+	tfs := &fakeTwoFactorService{user: user}
+	tf := NewTwoFactor(&fakeUserService{user: user}, tfs, &fakeLoginThrottle{failures: make(map[string]int)})
+
+	post := func(handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithUser(req.Context(), user))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	// a stolen session can't set up its own authenticator
+	post(tf.Enroll, "/account/2fa/enroll", url.Values{"password": {"wrongPASS"}})
+	if user.TOTPSecret != "" {
+		t.Errorf("Enroll(wrong password): expected no secret, got %q", user.TOTPSecret)
+	}
+
+	rr := post(tf.Enroll, "/account/2fa/enroll", url.Values{"password": {"correctPASS"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Enroll(): expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	body := rr.Body.String()
+	want := `href="otpauth://totp/Whatever:bozo@clown.net?algorithm=SHA1&amp;digits=6&amp;issuer=Whatever&amp;period=30&amp;secret=JBSWY3DPEHPK3PXP"`
+	if !strings.Contains(body, want) {
+		t.Errorf("Enroll(): expected the link %s, got %s", want, body)
+	}
+	if strings.Contains(body, "ZgotmplZ") {
+		t.Errorf("Enroll(): expected the link not to be filtered out")
+	}
+
+	post(tf.Confirm, "/account/2fa/confirm", url.Values{"code": {"123456"}, "password": {"wrongPASS"}})
+	if tfs.confirmed {
+		t.Errorf("Confirm(wrong password): expected enrollment not confirmed")
+	}
+	post(tf.Confirm, "/account/2fa/confirm", url.Values{"code": {"123456"}, "password": {"correctPASS"}})
+	if !tfs.confirmed {
+		t.Errorf("Confirm(): expected enrollment confirmed")
+	}
+}
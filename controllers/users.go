@@ -5,24 +5,48 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/email"
 	"github.com/peterpla/webdevgo/models"
-	"github.com/peterpla/webdevgo/rand"
 	"github.com/peterpla/webdevgo/views"
 )
 
 // Users ... [add documentation]
 type Users struct {
-	NewView   *views.View
-	LoginView *views.View
-	us        models.UserService
+	NewView       *views.View
+	LoginView     *views.View
+	ForgotPwView  *views.View
+	ResetPwView   *views.View
+	VerifyView    *views.View
+	AccountView   *views.View
+	TwoFactorView *views.View
+	us            models.UserService
+	ss            models.SessionService
+	tfs           models.TwoFactorService
+	lts           models.LoginThrottleService
+	cookies       cookie.Policy
+	emailer       *email.Client
 }
 
 // NewUsers ... [add documentation]
-func NewUsers(us models.UserService) *Users {
+func NewUsers(us models.UserService, ss models.SessionService,
+	tfs models.TwoFactorService, lts models.LoginThrottleService,
+	cookies cookie.Policy, emailer *email.Client) *Users {
 	return &Users{
-		NewView:   views.NewView("bootstrap", "users/new"),
-		LoginView: views.NewView("bootstrap", "users/login"),
-		us:        us,
+		NewView:       views.NewView("bootstrap", "users/new"),
+		LoginView:     views.NewView("bootstrap", "users/login"),
+		ForgotPwView:  views.NewView("bootstrap", "users/forgot_pw"),
+		ResetPwView:   views.NewView("bootstrap", "users/reset_pw"),
+		VerifyView:    views.NewView("bootstrap", "users/verify"),
+		AccountView:   views.NewView("bootstrap", "users/account"),
+		TwoFactorView: views.NewView("bootstrap", "users/two_factor"),
+		us:            us,
+		ss:            ss,
+		tfs:           tfs,
+		lts:           lts,
+		cookies:       cookies,
+		emailer:       emailer,
 	}
 }
 
@@ -31,7 +55,7 @@ func NewUsers(us models.UserService) *Users {
 //
 // GET /signup
 func (u *Users) New(w http.ResponseWriter, r *http.Request) {
-	u.NewView.Render(w, nil)
+	u.NewView.Render(w, r, nil)
 }
 
 // SignupForm ... [add documentation]
@@ -51,7 +75,7 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 
 	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
-		u.NewView.Render(w, vd)
+		u.NewView.Render(w, r, vd)
 		return
 	}
 	user := models.User{
@@ -62,16 +86,20 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 
 	if err := u.us.Create(&user); err != nil {
 		vd.SetAlert(err)
-		u.NewView.Render(w, vd)
+		u.NewView.Render(w, r, vd)
 		return
 	}
 
 	// fmt.Fprintf(w, "User is: %+v\n", user) // echo to web page
 	// fmt.Printf("User is: %+v\n", user)     // echo to stdout
 
+	// the user can ask for another link if this one doesn't arrive
+	if err := u.sendVerifyEmail(&user); err != nil {
+		log.Println(err)
+	}
+
 	// sign in the newly-created user
-	// remember token is NOT provided
-	err := u.signIn(w, &user)
+	err := u.signIn(w, r, &user)
 	if err != nil {
 		// user resource was created, but we could not signin; likely
 		// a transient problem, so redirect the user to login. Not optimal, but
@@ -84,7 +112,7 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// redirect to to pull Remember token from the user's cookie
-	// and confirm it matches stored RememberHash
+	// and confirm it matches a stored session
 	http.Redirect(w, r, "/cookietest", http.StatusFound)
 }
 
@@ -104,7 +132,16 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 
 	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, vd)
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+
+	// refuse without checking the password if there have been too
+	// many recent failures for this email address or IP
+	ip := clientIP(r)
+	if err := u.lts.Allow(form.Email, ip); err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
 		return
 	}
 
@@ -113,73 +150,571 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
+			u.loginFailed(form.Email, ip)
 			vd.AlertError("No user exists with that email address")
+		case models.ErrPasswordIncorrect:
+			u.loginFailed(form.Email, ip)
+			vd.SetAlert(err)
 		default:
 			vd.SetAlert(err)
 		}
-		u.LoginView.Render(w, vd)
+		u.LoginView.Render(w, r, vd)
 		return
 	}
+	// SUCCESS from Authenticate. With two-factor authentication
+	// enabled, the user must also enter a code before signing in, and
+	// failed logins are only forgotten once they have; otherwise
+	// redoing this step would reset the lockout on guessing codes.
+	if user.TwoFactorEnabled() {
+		if err := u.lts.Passed(form.Email, ip); err != nil {
+			log.Println(err)
+		}
+		if err := u.startTwoFactor(w, r, user); err != nil {
+			vd.SetAlert(err)
+			u.LoginView.Render(w, r, vd)
+			return
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+	if err := u.lts.Succeeded(form.Email, ip); err != nil {
+		log.Println(err)
+	}
 
-	// SUCCESS from Authenticate, sign in the user
-	// Remember token is NOT populated in User object
-	err = u.signIn(w, user)
+	// sign in the user
+	err = u.signIn(w, r, user)
 	if err != nil {
 		vd.SetAlert(err)
-		u.LoginView.Render(w, vd)
+		u.LoginView.Render(w, r, vd)
 		return
 	}
 
 	// redirect to to pull Remember token from the user's cookie
-	// and confirm it matches stored RememberHash
+	// and confirm it matches a stored session
 	http.Redirect(w, r, "/cookietest", http.StatusFound)
 }
 
-// signIn is used to sign in the given user, confirming the Remember token
-// from the user's cookie hashes to the RememberHash value stored in the user's
-// DB record
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
-	// at Signup/Create, the user was saved in the database
+// startTwoFactor sets the twofactor_token cookie, which lets the user
+// complete their login at /login/2fa by entering a code. Every path
+// that signs in a user with two-factor authentication enabled must go
+// through here rather than calling signIn.
+func (u *Users) startTwoFactor(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	token, err := u.tfs.LoginToken(user)
+	if err != nil {
+		return err
+	}
+	return u.cookies.Set(w, r, cookie.TwoFactor, token)
+}
 
-	if user.Remember == "" { //
-		token, err := rand.RememberToken() // generate a new Remember token
-		if err != nil {
-			return err
+// loginFailed counts a failed login towards the email address's and
+// IP's lockout thresholds. The user has already been shown an error,
+// so a failure here is only logged.
+func (u *Users) loginFailed(email, ip string) {
+	if err := u.lts.Failed(email, ip); err != nil {
+		log.Println(err)
+	}
+}
+
+// TwoFactorForm is used to enter an authenticator app or recovery code
+type TwoFactorForm struct {
+	Code string `schema:"code"`
+}
+
+// TwoFactor renders the second step of logging in, where a user with
+// two-factor authentication enabled enters their code
+//
+// GET /login/2fa
+func (u *Users) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, err := u.cookies.Get(r, cookie.TwoFactor); err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	u.TwoFactorView.Render(w, r, nil)
+}
+
+// CompleteTwoFactor is used to process the second step of logging in.
+// Wrong codes count towards the same lockout as wrong passwords, so
+// the code can't be guessed in the time the login token is valid.
+//
+// POST /login/2fa
+func (u *Users) CompleteTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+
+	token, err := u.cookies.Get(r, cookie.TwoFactor)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.completeTwoFactor(token, form.Code, clientIP(r))
+	if err != nil {
+		switch err {
+		case models.ErrTokenInvalid:
+			// took too long: start again from the password step
+			vd.AlertError("Your login has expired. Please log in again.")
+			u.LoginView.Render(w, r, vd)
+		default:
+			vd.SetAlert(err)
+			u.TwoFactorView.Render(w, r, vd)
 		}
-		user.Remember = token
+		return
+	}
 
-		// update the user's record with the RememberHash (but NOT the Remember token!)
-		// and write the user's record to the DB so we can look it up later
-		err = u.us.Update(user)
-		if err != nil {
-			return err
+	u.cookies.Clear(w, r, cookie.TwoFactor)
+
+	if err := u.signIn(w, r, user); err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+// completeTwoFactor checks the code for the login token, counting a
+// wrong code against the user's email address and the IP, and
+// refusing without checking it if either is locked out
+func (u *Users) completeTwoFactor(token, code, ip string) (*models.User, error) {
+	pending, err := u.tfs.LoginUser(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.lts.Allow(pending.Email, ip); err != nil {
+		return nil, err
+	}
+
+	user, err := u.tfs.CompleteLogin(token, code)
+	switch err {
+	case nil:
+		if err := u.lts.Succeeded(pending.Email, ip); err != nil {
+			log.Println(err)
 		}
-	} else {
-		fmt.Printf("user.Remember unexpectedly not empty: \"%s\"\n", user.Remember)
+	case models.ErrTwoFactorCodeInvalid:
+		u.loginFailed(pending.Email, ip)
+	}
+	return user, err
+}
+
+// signIn is used to sign in the given user on the requesting device.
+// Each sign in creates a new session with its own Remember token, so
+// signing in on one device never disturbs another. Only the token's
+// hash is saved in the DB; the token itself is stored only in the
+// device's cookie.
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session := models.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+	if err := u.ss.Create(&session); err != nil {
+		return err
 	}
 
 	// add the remember token to a cookie, the only place it is stored
-	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    user.Remember,
-		HttpOnly: true,
+	return u.cookies.Set(w, r, cookie.Remember, session.Token)
+}
+
+// Logout is used to sign out the current device. The remember_token
+// cookie is expired, and the device's session is deleted so the old
+// token can no longer be used to sign in.
+//
+// POST /logout
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
+	session, err := currentSession(u.ss, u.cookies, r)
+	if err == nil {
+		err = u.ss.Delete(session.ID)
+	}
+	if err != nil {
+		// the cookie is expired below; log the failure to delete the
+		// session but don't strand the user on an error page
+		log.Println(err)
 	}
-	http.SetCookie(w, &cookie)
-	return nil
+
+	u.cookies.Clear(w, r, cookie.Remember)
+	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-// CookieTest displays the cookie set on the current user
-func (u *Users) CookieTest(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("remember_token")
+// ResetPwForm is used by both the forgot password and the reset
+// password forms
+type ResetPwForm struct {
+	Email    string `schema:"email"`
+	Token    string `schema:"token"`
+	Password string `schema:"password"`
+}
+
+// InitiateReset is used to process the forgot password form. The
+// same message is shown whether or not the email address belongs to
+// an account, so the form can't be used to discover who has signed up.
+//
+// POST /forgot
+func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ForgotPwView.Render(w, r, vd)
+		return
+	}
+
+	user, token, err := u.us.InitiateReset(form.Email)
+	switch err {
+	case nil:
+		// a failure is only logged, since showing it would reveal
+		// that the account exists
+		if err := u.emailer.ResetPw(user.Name, user.Email, token); err != nil {
+			log.Println(err)
+		}
+	case models.ErrNotFound:
+		// fall through to the success message
+	default:
+		vd.SetAlert(err)
+		u.ForgotPwView.Render(w, r, vd)
+		return
+	}
+
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "If an account exists for that address, instructions for resetting your password have been emailed to it.",
+	}
+	u.ForgotPwView.Render(w, r, vd)
+}
+
+// ResetPw renders the reset password form, with the token
+// pre-filled from the link the user followed
+//
+// GET /reset?token=...
+func (u *Users) ResetPw(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.ResetPwView.Render(w, r, vd)
+}
+
+// CompleteReset is used to process the reset password form. On
+// success every existing session has been invalidated, so the user
+// is signed in afresh on this device only. The emailed link stands in
+// for the password alone: a user with two-factor authentication
+// enabled must still enter a code.
+//
+// POST /reset
+func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.CompleteReset(form.Token, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+
+	if user.TwoFactorEnabled() {
+		if err := u.startTwoFactor(w, r, user); err != nil {
+			// the password was changed; have the user log in with it
+			log.Println(err)
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	if err := u.signIn(w, r, user); err != nil {
+		// the password was changed; have the user log in with it
+		log.Println(err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+// verifyData is the view data for the email verification page
+type verifyData struct {
+	Email    string
+	Verified bool
+}
+
+// Verify is used to process the link in the verification email. With
+// no token, it tells the signed-in user (if any) to check their email.
+//
+// GET /verify?token=...
+func (u *Users) Verify(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var yield verifyData
+	if user := context.User(r.Context()); user != nil {
+		yield.Email = user.Email
+		yield.Verified = user.EmailVerified()
+	}
+	vd.Yield = &yield
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+
+	if _, err := u.us.VerifyEmail(token); err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	yield.Verified = true
+	u.VerifyView.Render(w, r, vd)
+}
+
+// ResendVerify emails the signed-in user a new verification link
+//
+// POST /verify/resend
+func (u *Users) ResendVerify(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	var vd views.Data
+	vd.Yield = &verifyData{
+		Email:    user.Email,
+		Verified: user.EmailVerified(),
+	}
+	if user.EmailVerified() {
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.sendVerifyEmail(user); err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "We sent you a new verification link.",
+	}
+	u.VerifyView.Render(w, r, vd)
+}
+
+// sendVerifyEmail emails the user a link to verify their email address
+func (u *Users) sendVerifyEmail(user *models.User) error {
+	token, err := u.us.EmailVerifyToken(user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return u.emailer.VerifyEmail(user.Name, user.Email, token)
+}
+
+// ProfileForm is the account page form for changing profile details
+type ProfileForm struct {
+	Name string `schema:"name"`
+}
+
+// EmailForm is the account page form for changing email address
+type EmailForm struct {
+	Email    string `schema:"email"`
+	Password string `schema:"password"`
+}
+
+// PasswordForm is the account page form for changing password
+type PasswordForm struct {
+	CurrentPassword string `schema:"current_password"`
+	NewPassword     string `schema:"new_password"`
+}
+
+// accountData is the view data for the account page. Each form is
+// pre-filled from the user, or with what was just submitted when
+// re-rendering after an error. Passwords are never echoed back.
+type accountData struct {
+	Profile  ProfileForm
+	Email    EmailForm
+	Verified bool
+
+	TwoFactorEnabled  bool
+	RecoveryCodesLeft int
+}
+
+// newAccountData returns account page data pre-filled from the user
+func (u *Users) newAccountData(user *models.User) *accountData {
+	data := &accountData{
+		Profile:          ProfileForm{Name: user.Name},
+		Email:            EmailForm{Email: user.Email},
+		Verified:         user.EmailVerified(),
+		TwoFactorEnabled: user.TwoFactorEnabled(),
+	}
+	if data.TwoFactorEnabled {
+		n, err := u.tfs.RecoveryCodesLeft(user)
+		if err != nil {
+			log.Println(err)
+		}
+		data.RecoveryCodesLeft = n
+	}
+	return data
+}
+
+// Account renders the account settings page
+//
+// GET /account
+func (u *Users) Account(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = u.newAccountData(context.User(r.Context()))
+	u.AccountView.Render(w, r, vd)
+}
+
+// UpdateProfile is used to process the account page's profile form
+//
+// POST /account/profile
+func (u *Users) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	data := u.newAccountData(user)
+
+	var vd views.Data
+	vd.Yield = data
+	if err := parseForm(r, &data.Profile); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
 		return
 	}
 
-	user, err := u.us.ByRemember(cookie.Value)
+	user.Name = data.Profile.Name
+	if err := u.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your profile has been updated.",
+	}
+	u.AccountView.Render(w, r, vd)
+}
+
+// UpdateEmail is used to process the account page's email form. The
+// user must confirm their current password, and the new address must
+// be verified again before it is trusted.
+//
+// POST /account/email
+func (u *Users) UpdateEmail(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	data := u.newAccountData(user)
+
+	var vd views.Data
+	vd.Yield = data
+	if err := parseForm(r, &data.Email); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	password := data.Email.Password
+	data.Email.Password = ""
+
+	user, err := confirmPassword(u.us, u.lts, user.Email, password, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if models.NormalizeEmail(data.Email.Email) == user.Email {
+		// the address we already have, which stays verified if it was
+		vd.Yield = u.newAccountData(user)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	user.Email = data.Email.Email
+	user.EmailVerifiedAt = nil
+	if err := u.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	data = u.newAccountData(user)
+	vd.Yield = data
+	if err := u.sendVerifyEmail(user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your email address has been changed. We sent a verification link to the new address.",
+	}
+	u.AccountView.Render(w, r, vd)
+}
+
+// UpdatePassword is used to process the account page's password form.
+// The user must confirm their current password. Every other device is
+// signed out, since whoever knew the old password may be using them.
+//
+// POST /account/password
+func (u *Users) UpdatePassword(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	var vd views.Data
+	vd.Yield = u.newAccountData(user)
+	var form PasswordForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	user, err := confirmPassword(u.us, u.lts, user.Email, form.CurrentPassword, r)
+	if err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	// Update only re-hashes a password when one is provided
+	if form.NewPassword == "" {
+		vd.SetAlert(models.ErrPasswordRequired)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	user.Password = form.NewPassword
+	if err := u.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if current, err := currentSession(u.ss, u.cookies, r); err == nil {
+		err = u.ss.DeleteOthers(user.ID, current.ID)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your password has been changed, and your other devices have been signed out.",
+	}
+	u.AccountView.Render(w, r, vd)
+}
+
+// CookieTest displays the user signed in via the remember_token cookie,
+// as loaded into the request context by the User middleware
+func (u *Users) CookieTest(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user == nil {
+		http.Error(w, "no signed-in user", http.StatusInternalServerError)
 		return
 	}
-	fmt.Println(w, user)
+	fmt.Fprintln(w, user)
 }
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/email"
+	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/totp"
+	"github.com/peterpla/webdevgo/views"
+)
+
+func init() {
+	// the tests run in this directory, not the repo's root
+	views.LayoutDir = "../views/layouts/"
+	views.TemplateDir = "../views/"
+}
+
+// fakeUserService authenticates a single user with password
+// "correctPASS"
+type fakeUserService struct {
+	models.UserService
+	user *models.User
+}
+
+func (us *fakeUserService) Authenticate(email, password string) (*models.User, error) {
+	if email != us.user.Email {
+		return nil, models.ErrNotFound
+	}
+	if password != "correctPASS" {
+		return nil, models.ErrPasswordIncorrect
+	}
+	return us.user, nil
+}
+
+// fakeSessionService creates sessions without storing them
+type fakeSessionService struct {
+	models.SessionService
+}
+
+func (ss *fakeSessionService) Create(session *models.Session) error {
+	session.Token = "remember-token"
+	return nil
+}
+
+// fakeTwoFactorService accepts only the code "123456"
+type fakeTwoFactorService struct {
+	models.TwoFactorService
+	user      *models.User
+	confirmed bool
+}
+
+func (tfs *fakeTwoFactorService) BeginEnrollment(user *models.User) error {
+	user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	return nil
+}
+
+func (tfs *fakeTwoFactorService) ConfirmEnrollment(user *models.User, code string) ([]string, error) {
+	if code != "123456" {
+		return nil, models.ErrTwoFactorCodeInvalid
+	}
+	tfs.confirmed = true
+	return []string{"abcd-ef23"}, nil
+}
+
+func (tfs *fakeTwoFactorService) ProvisioningURI(user *models.User) string {
+	return totp.URI("Whatever", user.Email, user.TOTPSecret)
+}
+
+func (tfs *fakeTwoFactorService) LoginToken(user *models.User) (string, error) {
+	return "login-token", nil
+}
+
+func (tfs *fakeTwoFactorService) LoginUser(token string) (*models.User, error) {
+	if token != "login-token" {
+		return nil, models.ErrTokenInvalid
+	}
+	return tfs.user, nil
+}
+
+func (tfs *fakeTwoFactorService) CompleteLogin(token, code string) (*models.User, error) {
+	if _, err := tfs.LoginUser(token); err != nil {
+		return nil, err
+	}
+	if code != "123456" {
+		return nil, models.ErrTwoFactorCodeInvalid
+	}
+	return tfs.user, nil
+}
+
+// fakeLoginThrottle counts login attempts by email address as failed
+// until they're taken back, locking it out at 3
+type fakeLoginThrottle struct {
+	models.LoginThrottleService
+	failures map[string]int
+}
+
+func (lts *fakeLoginThrottle) Allow(email, ip string) error {
+	if lts.failures[email] >= 3 {
+		return models.ErrAccountLocked
+	}
+	lts.failures[email]++
+	return nil
+}
+
+func (lts *fakeLoginThrottle) Failed(email, ip string) error {
+	return nil
+}
+
+func (lts *fakeLoginThrottle) Passed(email, ip string) error {
+	lts.failures[email]--
+	return nil
+}
+
+func (lts *fakeLoginThrottle) Succeeded(email, ip string) error {
+	delete(lts.failures, email)
+	return nil
+}
+
+func TestLoginTwoFactorFailuresCarryOver(t *testing.T) {
+	enabled := time.Now()
+	user := &models.User{Email: "bozo@clown.net", TOTPEnabledAt: &enabled}
+	lts := &fakeLoginThrottle{failures: make(map[string]int)}
+	u := NewUsers(&fakeUserService{user: user}, &fakeSessionService{}, &fakeTwoFactorService{user: user}, lts,
+		cookie.Policy{}, email.NewClient(&email.Memory{}, "test@whatever.com", "http://localhost:3000"))
+
+	post := func(handler http.HandlerFunc, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	login := url.Values{"email": {user.Email}, "password": {"correctPASS"}}
+	wrongCode := url.Values{"code": {"000000"}}
+
+	// the right password, then a wrong code, three times over; the
+	// password step mustn't forget the wrong codes before it
+	for i := 1; i <= 3; i++ {
+		rr := post(u.Login, "/login", login)
+		if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/login/2fa" {
+			t.Fatalf("Login() %d: expected a redirect to /login/2fa, got %d %q",
+				i, rr.Code, rr.Header().Get("Location"))
+		}
+		cookies := rr.Result().Cookies()
+		post(u.CompleteTwoFactor, "/login/2fa", wrongCode, cookies...)
+		if got := lts.failures[user.Email]; got != i {
+			t.Errorf("CompleteTwoFactor() %d: expected %d failures, got %d", i, i, got)
+		}
+	}
+
+	// the lockout now applies to the password step too
+	rr := post(u.Login, "/login", login)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), models.ErrAccountLocked.Public()) {
+		t.Errorf("Login() after wrong codes: expected the lockout alert, got %d", rr.Code)
+	}
+
+	// a right code completes the login and forgets the failures
+	lts.failures[user.Email] = 1
+	rr = post(u.Login, "/login", login)
+	post(u.CompleteTwoFactor, "/login/2fa", url.Values{"code": {"123456"}}, rr.Result().Cookies()...)
+	if got := lts.failures[user.Email]; got != 0 {
+		t.Errorf("CompleteTwoFactor(right code): expected no failures, got %d", got)
+	}
+}
@@ -0,0 +1,217 @@
+// Package cookie sets and reads the app's cookies according to a
+// single Policy, so every cookie that carries a credential gets the
+// same hardening: an expiry, SameSite, Secure when served over TLS,
+// and optionally a "__Host-" name prefix and encrypted values.
+package cookie
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// ErrInvalid is returned by Get for a cookie whose value can't be
+// decrypted, e.g. because it was altered or its key was retired
+var ErrInvalid = errors.New("cookie: value is invalid")
+
+// hostPrefix locks a cookie to the exact host that set it, over HTTPS,
+// for the whole site. Browsers refuse such cookies otherwise.
+const hostPrefix = "__Host-"
+
+// Spec describes one of the app's cookies
+type Spec struct {
+	Name string
+
+	// MaxAge is how long the browser keeps the cookie. Zero makes a
+	// session cookie, discarded when the browser is closed.
+	MaxAge time.Duration
+
+	SameSite http.SameSite
+}
+
+// The app's cookies
+var (
+	// Remember holds a signed-in device's remember token
+	Remember = Spec{
+		Name:     "remember_token",
+		MaxAge:   30 * 24 * time.Hour,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	// TwoFactor holds the token for a login waiting on a second factor
+	TwoFactor = Spec{
+		Name:     "twofactor_token",
+		MaxAge:   5 * time.Minute,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	// CSRF holds the secret a browser's anti-forgery tokens are made from
+	CSRF = Spec{
+		Name:     "csrf_secret",
+		SameSite: http.SameSiteLaxMode,
+	}
+)
+
+// Policy sets and reads cookies. The zero value sets HttpOnly cookies
+// with each Spec's expiry and SameSite mode, Secure on requests served
+// over TLS, and stores values as-is.
+type Policy struct {
+	// Secure marks every cookie Secure, even on requests that didn't
+	// arrive over TLS, e.g. behind a proxy that terminates TLS
+	Secure bool
+
+	// TrustProxy treats requests with "X-Forwarded-Proto: https" as
+	// served over TLS. Only set it behind a proxy that sets the header.
+	TrustProxy bool
+
+	// HostPrefix adds the "__Host-" prefix to the names of Secure
+	// cookies, so they can't be set by another subdomain
+	HostPrefix bool
+
+	// Keys, if set, encrypts and authenticates cookie values with
+	// AES-GCM, using the current key. Cookies encrypted with older
+	// keys can still be read.
+	Keys *hash.Keyring
+}
+
+// Set sets the cookie described by spec to value
+func (p Policy) Set(w http.ResponseWriter, r *http.Request, spec Spec, value string) error {
+	if p.Keys != nil {
+		var err error
+		if value, err = p.encrypt(spec, value); err != nil {
+			return err
+		}
+	}
+	c := p.cookie(r, spec)
+	c.Value = value
+	if spec.MaxAge > 0 {
+		c.MaxAge = int(spec.MaxAge.Seconds())
+		c.Expires = time.Now().Add(spec.MaxAge)
+	}
+	http.SetCookie(w, c)
+	return nil
+}
+
+// Get returns the value of the cookie described by spec. A missing
+// cookie results in http.ErrNoCookie, and one that can't be decrypted
+// in ErrInvalid.
+func (p Policy) Get(r *http.Request, spec Spec) (string, error) {
+	c, err := r.Cookie(p.name(r, spec))
+	if err != nil {
+		return "", err
+	}
+	if p.Keys == nil {
+		return c.Value, nil
+	}
+	return p.decrypt(spec, c.Value)
+}
+
+// Clear tells the browser to delete the cookie described by spec
+func (p Policy) Clear(w http.ResponseWriter, r *http.Request, spec Spec) {
+	c := p.cookie(r, spec)
+	c.MaxAge = -1
+	c.Expires = time.Unix(0, 0)
+	http.SetCookie(w, c)
+}
+
+// cookie returns the cookie described by spec, without a value
+// or expiry
+func (p Policy) cookie(r *http.Request, spec Spec) *http.Cookie {
+	return &http.Cookie{
+		Name:     p.name(r, spec),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   p.secure(r),
+		SameSite: spec.SameSite,
+	}
+}
+
+// name returns the cookie's name, with the "__Host-" prefix if it
+// applies to this request
+func (p Policy) name(r *http.Request, spec Spec) string {
+	if p.HostPrefix && p.secure(r) {
+		return hostPrefix + spec.Name
+	}
+	return spec.Name
+}
+
+// secure reports whether cookies set in response to r are Secure
+func (p Policy) secure(r *http.Request) bool {
+	if p.Secure || r.TLS != nil {
+		return true
+	}
+	return p.TrustProxy && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+/* ********** ********** ********** */
+/*            encryption            */
+
+// encoding is URL safe, so encrypted values are valid cookie values
+var encoding = base64.RawURLEncoding
+
+// encrypt returns value encrypted with the current key, as
+// "<key ID>.<nonce and ciphertext>". The cookie's name is
+// authenticated too, so a value can't be moved to another cookie.
+func (p Policy) encrypt(spec Spec, value string) (string, error) {
+	key := p.Keys.Current()
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := rand.Bytes(aead.NonceSize())
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(spec.Name))
+	return key.ID + "." + encoding.EncodeToString(sealed), nil
+}
+
+// decrypt reverses encrypt, with whichever key the value names
+func (p Policy) decrypt(spec Spec, value string) (string, error) {
+	i := strings.Index(value, ".")
+	if i < 0 {
+		return "", ErrInvalid
+	}
+	key, ok := p.Keys.Key(value[:i])
+	if !ok {
+		return "", ErrInvalid
+	}
+	sealed, err := encoding.DecodeString(value[i+1:])
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrInvalid
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(spec.Name))
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return string(plain), nil
+}
+
+// newAEAD returns AES-256-GCM keyed from the key's secret. The secret
+// is hashed with a label first, so the AES key differs from any other
+// use of the same secret (e.g. as an HMAC key).
+func newAEAD(key hash.Key) (cipher.AEAD, error) {
+	aesKey := sha256.Sum256([]byte("cookie encryption:" + key.Secret))
+	block, err := aes.NewCipher(aesKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
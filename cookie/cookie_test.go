@@ -0,0 +1,144 @@
+package cookie
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterpla/webdevgo/hash"
+)
+
+// roundTrip sets the cookie with p in response to req, and returns it
+// along with a request from the same browser carrying it back
+func roundTrip(t *testing.T, p Policy, req *http.Request, spec Spec, value string) (*http.Cookie, *http.Request) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	if err := p.Set(rr, req, spec, value); err != nil {
+		t.Fatalf("Set(): expected nil, got \"%v\"", err)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Set(): expected 1 cookie, got %v", cookies)
+	}
+	next := httptest.NewRequest("GET", "/", nil)
+	next.TLS = req.TLS
+	next.Header.Set("X-Forwarded-Proto", req.Header.Get("X-Forwarded-Proto"))
+	next.AddCookie(cookies[0])
+	return cookies[0], next
+}
+
+func TestPolicyAttributes(t *testing.T) {
+	plain := httptest.NewRequest("GET", "/", nil)
+	overTLS := httptest.NewRequest("GET", "/", nil)
+	overTLS.TLS = &tls.ConnectionState{}
+	proxied := httptest.NewRequest("GET", "/", nil)
+	proxied.Header.Set("X-Forwarded-Proto", "https")
+
+	var tests = []struct {
+		desc   string
+		policy Policy
+		req    *http.Request
+		spec   Spec
+		name   string
+		secure bool
+	}{
+		{"plain HTTP", Policy{HostPrefix: true}, plain, Remember, "remember_token", false},
+		{"TLS", Policy{}, overTLS, Remember, "remember_token", true},
+		{"TLS with prefix", Policy{HostPrefix: true}, overTLS, TwoFactor, "__Host-twofactor_token", true},
+		{"untrusted proxy", Policy{HostPrefix: true}, proxied, Remember, "remember_token", false},
+		{"trusted proxy", Policy{TrustProxy: true, HostPrefix: true}, proxied, Remember, "__Host-remember_token", true},
+		{"always secure", Policy{Secure: true}, plain, CSRF, "csrf_secret", true},
+	}
+
+	for _, test := range tests {
+		c, next := roundTrip(t, test.policy, test.req, test.spec, "value")
+		if c.Name != test.name || c.Secure != test.secure {
+			t.Errorf("%s: expected %q with Secure %v, got %q with Secure %v",
+				test.desc, test.name, test.secure, c.Name, c.Secure)
+		}
+		if !c.HttpOnly || c.Path != "/" || c.SameSite != test.spec.SameSite {
+			t.Errorf("%s: expected HttpOnly, Path \"/\" and SameSite %v, got %+v",
+				test.desc, test.spec.SameSite, c)
+		}
+		if c.MaxAge != int(test.spec.MaxAge.Seconds()) {
+			t.Errorf("%s: expected MaxAge %d, got %d", test.desc, int(test.spec.MaxAge.Seconds()), c.MaxAge)
+		}
+		if got, err := test.policy.Get(next, test.spec); err != nil || got != "value" {
+			t.Errorf("%s: Get(): expected \"value\", got %q, \"%v\"", test.desc, got, err)
+		}
+	}
+
+	if _, err := (Policy{}).Get(plain, Remember); err != http.ErrNoCookie {
+		t.Errorf("Get(no cookie): expected \"%v\", got \"%v\"", http.ErrNoCookie, err)
+	}
+}
+
+func TestPolicyEncryption(t *testing.T) {
+	v1 := hash.Key{ID: "v1", Secret: "older-secret"}
+	v2 := hash.Key{ID: "v2", Secret: "newer-secret"}
+	oldKeys, err := hash.NewKeyring(v1)
+	if err != nil {
+		t.Fatalf("NewKeyring(): expected nil, got \"%v\"", err)
+	}
+	newKeys, err := hash.NewKeyring(v2, v1)
+	if err != nil {
+		t.Fatalf("NewKeyring(): expected nil, got \"%v\"", err)
+	}
+	p := Policy{Keys: newKeys}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	c, next := roundTrip(t, p, req, Remember, "my-token")
+	if strings.Contains(c.Value, "my-token") || !strings.HasPrefix(c.Value, "v2.") {
+		t.Errorf("Set(): expected a value encrypted with \"v2\", got %q", c.Value)
+	}
+	if got, err := p.Get(next, Remember); err != nil || got != "my-token" {
+		t.Errorf("Get(): expected \"my-token\", got %q, \"%v\"", got, err)
+	}
+
+	// values encrypted with an older key can still be read
+	_, next = roundTrip(t, Policy{Keys: oldKeys}, req, Remember, "old-token")
+	if got, err := p.Get(next, Remember); err != nil || got != "old-token" {
+		t.Errorf("Get(older key): expected \"old-token\", got %q, \"%v\"", got, err)
+	}
+
+	tampered := []byte(c.Value)
+	tampered[len(tampered)-3] ^= 'A' ^ 'B'
+	invalid := map[string]string{
+		"tampered":    string(tampered),
+		"unknown key": "v3" + strings.TrimPrefix(c.Value, "v2"),
+		"no key":      "plain-token",
+		"truncated":   "v2.AAAA",
+	}
+	for desc, value := range invalid {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: Remember.Name, Value: value})
+		if _, err := p.Get(r, Remember); err != ErrInvalid {
+			t.Errorf("Get(%s): expected \"%v\", got \"%v\"", desc, ErrInvalid, err)
+		}
+	}
+
+	// a value can't be moved to another cookie
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: TwoFactor.Name, Value: c.Value})
+	if _, err := p.Get(r, TwoFactor); err != ErrInvalid {
+		t.Errorf("Get(moved value): expected \"%v\", got \"%v\"", ErrInvalid, err)
+	}
+}
+
+func TestPolicyClear(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	Policy{HostPrefix: true}.Clear(rr, req, Remember)
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Clear(): expected 1 cookie, got %v", cookies)
+	}
+	c := cookies[0]
+	if c.Name != "__Host-remember_token" || c.Value != "" || c.MaxAge >= 0 || !c.Secure {
+		t.Errorf("Clear(): expected an expired, empty, Secure \"__Host-remember_token\", got %+v", c)
+	}
+}
@@ -0,0 +1,200 @@
+// Package diskcache is a size-bounded cache of byte slices kept as
+// files in a directory, evicting the least recently used first.
+package diskcache
+
+import (
+	"container/list"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInvalidKey is returned for keys that aren't at least three
+// lowercase hex digits, e.g. a hex-encoded hash
+var ErrInvalidKey = errors.New("diskcache: key must be lowercase hex")
+
+// Cache is a least recently used cache of files in a directory,
+// holding at most MaxBytes. It is safe for concurrent use, but
+// expects to be the only user of its directory.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	recency *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+}
+
+// entry is a cached file
+type entry struct {
+	key  string
+	size int64
+}
+
+// New returns a Cache of the files in dir, creating it if needed.
+// Files already there are kept, oldest evicted first, so the cache
+// survives restarts.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		recency:  list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	type found struct {
+		entry
+		modTime time.Time
+	}
+	var files []found
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() && validKey(fi.Name()) && path == c.path(fi.Name()) {
+			files = append(files, found{entry{fi.Name(), fi.Size()}, fi.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		e := f.entry
+		c.entries[e.key] = c.recency.PushBack(&e)
+		c.size += e.size
+	}
+	c.evict()
+	return c, nil
+}
+
+// Get returns the data cached under key, if any
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if !validKey(key) {
+		return nil, false
+	}
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.recency.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		// removed from under us; forget it
+		c.mu.Lock()
+		if c.entries[key] == el {
+			c.remove(el)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	// record the use, so recency survives a restart
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	return data, true
+}
+
+// Put caches data under key, evicting the least recently used files
+// to make room. Data larger than the whole cache isn't kept.
+func (c *Cache) Put(key string, data []byte) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	if int64(len(data)) > c.maxBytes {
+		return nil
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.recency.PushFront(&entry{key, int64(len(data))})
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// Size returns the total size of the cached files
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// evict removes the least recently used files until the cache is
+// within its limit. c.mu must be held.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes {
+		el := c.recency.Back()
+		if el == nil {
+			return
+		}
+		os.Remove(c.path(el.Value.(*entry).key))
+		c.remove(el)
+	}
+}
+
+// remove forgets a cached file. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	e := c.recency.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// path returns the file a key is cached in. Files are spread over
+// subdirectories named by the key's first two digits, so no one
+// directory grows too large.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// validKey reports whether key is usable as a file name everywhere
+func validKey(key string) bool {
+	if len(key) < 3 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !('0' <= key[i] && key[i] <= '9' || 'a' <= key[i] && key[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
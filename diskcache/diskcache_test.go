@@ -0,0 +1,109 @@
+package diskcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("TempDir(): expected nil, got \"%v\"", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(dir, 30)
+	if err != nil {
+		t.Fatalf("New(): expected nil, got \"%v\"", err)
+	}
+	for _, key := range []string{"aaa1", "bbb2", "ccc3"} {
+		if err := c.Put(key, []byte(strings.Repeat(key[:1], 10))); err != nil {
+			t.Fatalf("Put(%s): expected nil, got \"%v\"", key, err)
+		}
+	}
+	if data, ok := c.Get("aaa1"); !ok || string(data) != "aaaaaaaaaa" {
+		t.Errorf("Get(aaa1): expected \"aaaaaaaaaa\", got %q, %v", data, ok)
+	}
+
+	// bbb2 is now the least recently used, so is evicted first
+	if err := c.Put("ddd4", []byte("dddddddddd")); err != nil {
+		t.Fatalf("Put(ddd4): expected nil, got \"%v\"", err)
+	}
+	if _, ok := c.Get("bbb2"); ok {
+		t.Errorf("Get(bbb2): expected it evicted")
+	}
+	if _, err := os.Stat(c.path("bbb2")); !os.IsNotExist(err) {
+		t.Errorf("Put(): expected the evicted file removed, got \"%v\"", err)
+	}
+	for _, key := range []string{"aaa1", "ccc3", "ddd4"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Get(%s): expected it cached", key)
+		}
+	}
+	if c.Size() != 30 {
+		t.Errorf("Size(): expected 30, got %d", c.Size())
+	}
+
+	// replacing an entry doesn't count it twice, and data larger than
+	// the cache isn't kept
+	c.Put("ddd4", []byte("d"))
+	c.Put("eee5", []byte(strings.Repeat("e", 31)))
+	if c.Size() != 21 {
+		t.Errorf("Size(): expected 21, got %d", c.Size())
+	}
+	if _, ok := c.Get("eee5"); ok {
+		t.Errorf("Get(eee5): expected oversized data not cached")
+	}
+
+	for _, key := range []string{"", "ab", "ABCD", "../etc", "abc/def"} {
+		if err := c.Put(key, []byte("x")); err != ErrInvalidKey {
+			t.Errorf("Put(%q): expected \"%v\", got \"%v\"", key, ErrInvalidKey, err)
+		}
+	}
+
+	// a new Cache over the same directory finds the files, and
+	// evicts the least recently used to fit a smaller limit
+	c, err = New(dir, 15)
+	if err != nil {
+		t.Fatalf("New(reopen): expected nil, got \"%v\"", err)
+	}
+	if c.Size() > 15 {
+		t.Errorf("New(reopen): expected at most 15 bytes, got %d", c.Size())
+	}
+	if data, ok := c.Get("ddd4"); !ok || string(data) != "d" {
+		t.Errorf("Get(ddd4): expected \"d\" to survive reopening, got %q, %v", data, ok)
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("TempDir(): expected nil, got \"%v\"", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := New(dir, 100)
+	if err != nil {
+		t.Fatalf("New(): expected nil, got \"%v\"", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("%04x", (i*50+j)%40)
+				c.Put(key, []byte("0123456789"))
+				c.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.Size() > 100 {
+		t.Errorf("Size(): expected at most 100, got %d", c.Size())
+	}
+}
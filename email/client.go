@@ -0,0 +1,85 @@
+package email
+
+import (
+	"net/mail"
+	"net/url"
+
+	"github.com/peterpla/webdevgo/views"
+)
+
+// Client renders our templated emails and sends them through a Mailer
+type Client struct {
+	mailer  Mailer
+	from    string
+	baseURL string
+	resetPw *views.EmailView
+	verify  *views.EmailView
+}
+
+// NewClient returns a Client that sends mail from the provided address.
+// baseURL (e.g. "https://whatever.com") is used to build links.
+func NewClient(mailer Mailer, from, baseURL string) *Client {
+	return &Client{
+		mailer:  mailer,
+		from:    from,
+		baseURL: baseURL,
+		resetPw: views.NewEmailView("emails/reset_pw"),
+		verify:  views.NewEmailView("emails/verify_email"),
+	}
+}
+
+// ResetPw emails the user a link to reset their password
+func (c *Client) ResetPw(toName, toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	data := struct {
+		Name  string
+		URL   string
+		Token string
+	}{
+		Name:  toName,
+		URL:   c.baseURL + "/reset?" + v.Encode(),
+		Token: token,
+	}
+	return c.send(toName, toEmail, "Reset your password", c.resetPw, data)
+}
+
+// VerifyEmail emails the user a link to verify their email address
+func (c *Client) VerifyEmail(toName, toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	data := struct {
+		Name string
+		URL  string
+	}{
+		Name: toName,
+		URL:  c.baseURL + "/verify?" + v.Encode(),
+	}
+	return c.send(toName, toEmail, "Verify your email address", c.verify, data)
+}
+
+// send renders the view with data and sends the result
+func (c *Client) send(toName, toEmail, subject string, ev *views.EmailView, data interface{}) error {
+	html, text, err := ev.Render(data)
+	if err != nil {
+		return err
+	}
+	msg := &Message{
+		From:    c.from,
+		To:      buildAddress(toName, toEmail),
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	}
+	return c.mailer.Send(msg)
+}
+
+// buildAddress formats a "Name <email>" address, or just the
+// email address if the name is empty
+func buildAddress(name, email string) string {
+	if name == "" {
+		return email
+	}
+	addr := mail.Address{Name: name, Address: email}
+	return addr.String()
+}
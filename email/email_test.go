@@ -0,0 +1,114 @@
+package email
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peterpla/webdevgo/views"
+)
+
+func TestMain(m *testing.M) {
+	// templates are loaded relative to the repository root
+	views.LayoutDir = "../views/layouts/"
+	views.TemplateDir = "../views/"
+	os.Exit(m.Run())
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:    "support@whatever.com",
+		To:      "bozo@clown.net",
+		Subject: "Héllo",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	}
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("msg.Bytes(): expected nil, got \"%v\"", err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage(): expected nil, got \"%v\"", err)
+	}
+	dec := new(mime.WordDecoder)
+	if subject, _ := dec.DecodeHeader(parsed.Header.Get("Subject")); subject != msg.Subject {
+		t.Errorf("Subject: got %q, want %q", subject, msg.Subject)
+	}
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("mime.ParseMediaType(): expected nil, got \"%v\"", err)
+	}
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []string{msg.Text, msg.HTML} {
+		part, err := mr.NextPart() // quoted-printable is decoded for us
+		if err != nil {
+			t.Fatalf("mr.NextPart(): expected nil, got \"%v\"", err)
+		}
+		body, _ := ioutil.ReadAll(part)
+		if string(body) != want {
+			t.Errorf("part body: got %q, want %q", body, want)
+		}
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	md, err := NewMaildir(dir)
+	if err != nil {
+		t.Fatalf("NewMaildir(): expected nil, got \"%v\"", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := md.Send(&Message{From: "a@b.com", To: "c@d.com", Subject: "hi"}); err != nil {
+			t.Fatalf("md.Send(): expected nil, got \"%v\"", err)
+		}
+	}
+
+	newMail, _ := ioutil.ReadDir(filepath.Join(dir, "new"))
+	tmpMail, _ := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	if len(newMail) != 2 || len(tmpMail) != 0 {
+		t.Errorf("expected 2 messages in new/ and none in tmp/, got %d and %d", len(newMail), len(tmpMail))
+	}
+}
+
+func TestClientResetPw(t *testing.T) {
+	mem := &Memory{}
+	c := NewClient(mem, "support@whatever.com", "https://whatever.com")
+
+	if err := c.ResetPw("Bozo", "bozo@clown.net", "a+token/="); err != nil {
+		t.Fatalf("c.ResetPw(): expected nil, got \"%v\"", err)
+	}
+
+	msgs := mem.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.To != `"Bozo" <bozo@clown.net>` {
+		t.Errorf("To: got %q", msg.To)
+	}
+	link := "https://whatever.com/reset?token=a%2Btoken%2F%3D"
+	if !strings.Contains(msg.Text, link) {
+		t.Errorf("text body missing link %q:\n%s", link, msg.Text)
+	}
+	if !strings.Contains(msg.HTML, "Whatever.com") || !strings.Contains(msg.HTML, "Reset your password") {
+		t.Errorf("HTML body missing branding or link text:\n%s", msg.HTML)
+	}
+
+	mem.Reset()
+	if len(mem.Messages()) != 0 {
+		t.Errorf("expected no messages after Reset")
+	}
+}
@@ -0,0 +1,54 @@
+package email
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Maildir is a Mailer that writes each message to a maildir on the
+// local filesystem instead of sending it. Point a mail client at the
+// directory to read what would have been sent during development.
+type Maildir struct {
+	Dir string
+}
+
+// maildirSeq makes file names unique within this process
+var maildirSeq uint64
+
+// NewMaildir returns a Maildir mailer, creating the maildir's
+// tmp, new and cur subdirectories if needed
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &Maildir{Dir: dir}, nil
+}
+
+// Send writes msg into the maildir's "new" directory. As the maildir
+// format requires, the file is written under "tmp" first and then
+// moved, so readers never see a partial message.
+func (md *Maildir) Send(msg *Message) error {
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().Unix(), os.Getpid(),
+		atomic.AddUint64(&maildirSeq, 1), host)
+
+	tmp := filepath.Join(md.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(md.Dir, "new", name))
+}
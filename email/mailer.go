@@ -0,0 +1,86 @@
+// Package email sends the mail our application needs: password
+// resets, address verification and notifications. Messages are
+// delivered through a pluggable Mailer so development and tests
+// never need a real mail server.
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"time"
+
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// Mailer delivers a single message
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// Message is an email with both an HTML and a plain text body
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Bytes returns the message encoded as RFC 5322 multipart/alternative
+// mail, ready to hand to an SMTP server or write to a file
+func (msg *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	id, err := rand.String(16)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []struct{ key, value string }{
+		{"From", msg.From},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@whatever.com>", id)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range header {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+
+	// plain text first: clients show the last part they understand
+	if err := writePart(mw, "text/plain; charset=utf-8", msg.Text); err != nil {
+		return nil, err
+	}
+	if err := writePart(mw, "text/html; charset=utf-8", msg.HTML); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writePart writes body as a quoted-printable part of the given content type
+func writePart(mw *multipart.Writer, contentType, body string) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	pw, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	qw := quotedprintable.NewWriter(pw)
+	if _, err := io.WriteString(qw, body); err != nil {
+		return err
+	}
+	return qw.Close()
+}
@@ -0,0 +1,32 @@
+package email
+
+import "sync"
+
+// Memory is a Mailer that keeps sent messages in memory,
+// for use in tests
+type Memory struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// Send records msg
+func (m *Memory) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns every message sent so far, oldest first
+func (m *Memory) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Message(nil), m.messages...)
+}
+
+// Reset discards all recorded messages
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
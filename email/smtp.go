@@ -0,0 +1,47 @@
+package email
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// SMTP is a Mailer that delivers messages through an SMTP server
+type SMTP struct {
+	// Addr is the server's host:port, e.g. "smtp.example.com:587"
+	Addr string
+	// Auth is used if the server supports it; may be nil
+	Auth smtp.Auth
+}
+
+// NewSMTP returns an SMTP mailer using PLAIN authentication with the
+// provided credentials. An empty username disables authentication.
+func NewSMTP(addr, username, password string) *SMTP {
+	s := &SMTP{Addr: addr}
+	if username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		s.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+// Send delivers msg to the SMTP server
+func (s *SMTP) Send(msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Addr, s.Auth, from.Address, []string{to.Address}, b)
+}
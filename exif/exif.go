@@ -0,0 +1,416 @@
+// Package exif reads the EXIF metadata cameras embed in JPEG files:
+// the camera and lens, exposure settings, when the photo was taken,
+// where, and which way up it is.
+package exif
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+// JPEG markers
+const (
+	markerSOI  = 0xD8 // start of image
+	markerEOI  = 0xD9 // end of image
+	markerSOS  = 0xDA // start of scan; the metadata is all before it
+	markerAPP1 = 0xE1 // holds the EXIF data
+)
+
+// exifHeader starts the APP1 segment holding EXIF data, before the
+// TIFF structure itself
+const exifHeader = "Exif\x00\x00"
+
+// maxIFDEntries limits the entries read from a single IFD, so a
+// damaged file can't make Decode do much work
+const maxIFDEntries = 1000
+
+// dateTimeLayout is the layout of EXIF dates and times
+const dateTimeLayout = "2006:01:02 15:04:05"
+
+// IFD tags
+const (
+	tagMake              = 0x010F
+	tagModel             = 0x0110
+	tagOrientation       = 0x0112
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagExposureTime      = 0x829A
+	tagFNumber           = 0x829D
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagFocalLength       = 0x920A
+	tagLensMake          = 0xA433
+	tagLensModel         = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// IFD entry types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+)
+
+// typeSizes is the size in bytes of a single value of each type
+var typeSizes = map[uint16]uint32{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+	typeSLong:     4,
+	typeSRational: 8,
+}
+
+var (
+	// ErrNotFound is returned when a JPEG has no EXIF metadata
+	ErrNotFound = errors.New("exif: no EXIF metadata found")
+
+	// ErrFormat is returned when the file isn't a JPEG, or its EXIF
+	// metadata is malformed
+	ErrFormat = errors.New("exif: malformed data")
+)
+
+// Rational is an unsigned fraction, as EXIF stores exposure settings
+type Rational struct {
+	Num, Den uint32
+}
+
+// Float returns the fraction's value, or 0 if it has no denominator
+func (r Rational) Float() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// Valid reports whether the fraction has a value
+func (r Rational) Valid() bool {
+	return r.Den != 0
+}
+
+// GPS is where a photo was taken. Latitude and Longitude are in
+// degrees, negative to the south and west; Altitude is in meters
+// above sea level.
+type GPS struct {
+	Latitude    float64
+	Longitude   float64
+	Altitude    float64
+	HasAltitude bool
+}
+
+// Exif is the metadata read from a JPEG. Fields the file didn't
+// include are left at their zero values.
+type Exif struct {
+	Make      string
+	Model     string
+	LensMake  string
+	LensModel string
+
+	// Orientation is how the stored pixels must be transformed to
+	// display the photo the right way up, from 1 (as stored) to 8;
+	// see imaging.Orient
+	Orientation int
+
+	ExposureTime Rational // in seconds
+	FNumber      Rational
+	FocalLength  Rational // in millimeters
+	ISO          int
+
+	// Taken is the camera's clock when the photo was taken. EXIF
+	// doesn't say which time zone that clock was set to, so it is
+	// returned as if UTC.
+	Taken time.Time
+
+	GPS *GPS
+}
+
+// Decode reads the EXIF metadata from a JPEG. It stops reading at the
+// start of the image data, which follows the metadata.
+func Decode(r io.Reader) (*Exif, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return nil, ErrFormat
+	}
+
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return nil, err
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return nil, ErrNotFound
+		}
+		// markers without a segment
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil || length < 2 {
+			return nil, ErrFormat
+		}
+		segment := io.LimitReader(br, int64(length)-2)
+		if marker != markerAPP1 {
+			if _, err := io.Copy(ioutil.Discard, segment); err != nil {
+				return nil, ErrFormat
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadAll(segment)
+		if err != nil || len(data) != int(length)-2 {
+			return nil, ErrFormat
+		}
+		// APP1 also holds XMP metadata, which is skipped
+		if !strings.HasPrefix(string(data), exifHeader) {
+			continue
+		}
+		return parse(data[len(exifHeader):])
+	}
+}
+
+// nextMarker reads up to and including the next marker, returning
+// the byte identifying it
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil || b != 0xFF {
+		return 0, ErrFormat
+	}
+	// any number of 0xFF bytes may pad the marker
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, ErrFormat
+		}
+	}
+	return b, nil
+}
+
+// tiff reads the TIFF structure EXIF data is kept in
+type tiff struct {
+	data    []byte
+	order   binary.ByteOrder
+	visited map[uint32]bool
+}
+
+// entry is a single IFD entry
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// parse reads the metadata from the TIFF structure data
+func parse(data []byte) (*Exif, error) {
+	t := &tiff{data: data, visited: make(map[uint32]bool)}
+	if len(data) < 8 {
+		return nil, ErrFormat
+	}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrFormat
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, ErrFormat
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	x := &Exif{
+		Make:        t.ascii(ifd0[tagMake]),
+		Model:       t.ascii(ifd0[tagModel]),
+		Orientation: t.int(ifd0[tagOrientation]),
+	}
+	if x.Orientation < 1 || x.Orientation > 8 {
+		x.Orientation = 0
+	}
+
+	// the sub-IFDs are optional, so damage there only loses the
+	// metadata they hold
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := t.ifd(uint32(t.int(e))); err == nil {
+			x.readExif(t, sub)
+		}
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if sub, err := t.ifd(uint32(t.int(e))); err == nil {
+			x.GPS = readGPS(t, sub)
+		}
+	}
+	return x, nil
+}
+
+// readExif reads the fields held in the Exif IFD
+func (x *Exif) readExif(t *tiff, ifd map[uint16]entry) {
+	x.ExposureTime = t.rational(ifd[tagExposureTime], 0)
+	x.FNumber = t.rational(ifd[tagFNumber], 0)
+	x.FocalLength = t.rational(ifd[tagFocalLength], 0)
+	x.ISO = t.int(ifd[tagISO])
+	x.LensMake = t.ascii(ifd[tagLensMake])
+	x.LensModel = t.ascii(ifd[tagLensModel])
+	for _, tag := range []uint16{tagDateTimeOriginal, tagDateTimeDigitized} {
+		taken, err := time.Parse(dateTimeLayout, t.ascii(ifd[tag]))
+		if err == nil {
+			x.Taken = taken
+			break
+		}
+	}
+}
+
+// readGPS reads the position held in the GPS IFD, returning nil if it
+// doesn't hold one
+func readGPS(t *tiff, ifd map[uint16]entry) *GPS {
+	lat, ok := t.degrees(ifd[tagGPSLatitude])
+	if !ok {
+		return nil
+	}
+	lon, ok := t.degrees(ifd[tagGPSLongitude])
+	if !ok {
+		return nil
+	}
+	if t.ascii(ifd[tagGPSLatitudeRef]) == "S" {
+		lat = -lat
+	}
+	if t.ascii(ifd[tagGPSLongitudeRef]) == "W" {
+		lon = -lon
+	}
+	if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return nil
+	}
+
+	gps := &GPS{Latitude: lat, Longitude: lon}
+	if alt := t.rational(ifd[tagGPSAltitude], 0); alt.Valid() {
+		gps.Altitude = alt.Float()
+		gps.HasAltitude = true
+		if t.int(ifd[tagGPSAltitudeRef]) == 1 {
+			gps.Altitude = -gps.Altitude
+		}
+	}
+	return gps
+}
+
+// ifd reads the entries of the IFD at offset, by tag
+func (t *tiff) ifd(offset uint32) (map[uint16]entry, error) {
+	// an IFD pointing back to one already read would loop forever
+	if t.visited[offset] {
+		return nil, ErrFormat
+	}
+	t.visited[offset] = true
+
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, ErrFormat
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	if n > maxIFDEntries || uint64(offset)+2+uint64(n)*12 > uint64(len(t.data)) {
+		return nil, ErrFormat
+	}
+
+	entries := make(map[uint16]entry, n)
+	for i := 0; i < n; i++ {
+		b := t.data[offset+2+uint32(i)*12:]
+		e := entry{
+			tag:   t.order.Uint16(b),
+			typ:   t.order.Uint16(b[2:]),
+			count: t.order.Uint32(b[4:]),
+		}
+		size, ok := typeSizes[e.typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(e.count)
+		if total <= 4 {
+			// small values are kept in the entry itself
+			e.value = b[8 : 8+total]
+		} else {
+			start := uint64(t.order.Uint32(b[8:]))
+			if start+total > uint64(len(t.data)) {
+				continue
+			}
+			e.value = t.data[start : start+total]
+		}
+		entries[e.tag] = e
+	}
+	return entries, nil
+}
+
+// ascii returns the entry's text, without its terminating NUL or the
+// padding some cameras add
+func (t *tiff) ascii(e entry) string {
+	if e.typ != typeASCII {
+		return ""
+	}
+	s := string(e.value)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// int returns the entry's first value as an integer, or 0 if it
+// isn't an integer type
+func (t *tiff) int(e entry) int {
+	if e.count == 0 {
+		return 0
+	}
+	switch e.typ {
+	case typeByte:
+		return int(e.value[0])
+	case typeShort:
+		return int(t.order.Uint16(e.value))
+	case typeLong:
+		return int(t.order.Uint32(e.value))
+	case typeSLong:
+		return int(int32(t.order.Uint32(e.value)))
+	default:
+		return 0
+	}
+}
+
+// rational returns the entry's i'th value as a Rational, or the zero
+// Rational if there isn't one
+func (t *tiff) rational(e entry, i uint32) Rational {
+	if e.typ != typeRational || i >= e.count {
+		return Rational{}
+	}
+	b := e.value[i*8:]
+	return Rational{Num: t.order.Uint32(b), Den: t.order.Uint32(b[4:])}
+}
+
+// degrees returns the entry's degrees, minutes and seconds in degrees
+func (t *tiff) degrees(e entry) (float64, bool) {
+	if e.count != 3 {
+		return 0, false
+	}
+	d, m, s := t.rational(e, 0), t.rational(e, 1), t.rational(e, 2)
+	if !d.Valid() || !m.Valid() || !s.Valid() {
+		return 0, false
+	}
+	return d.Float() + m.Float()/60 + s.Float()/3600, true
+}
@@ -0,0 +1,218 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+)
+
+// testEntry is an IFD entry for tiffBuilder, whose value is encoded
+// in the builder's byte order
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value interface{} // string, []uint16, []uint32 or []byte
+}
+
+// buildTIFF returns a TIFF structure holding IFD0, followed by the
+// Exif and GPS IFDs if they have entries
+func buildTIFF(order binary.ByteOrder, ifd0, exifIFD, gpsIFD []testEntry) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+
+	// lay each IFD out in turn, with its values after it; the
+	// pointers to the sub-IFDs are filled in once their offsets
+	// are known
+	if len(exifIFD) > 0 {
+		ifd0 = append(ifd0, testEntry{tagExifIFD, typeLong, 1, []uint32{0}})
+	}
+	if len(gpsIFD) > 0 {
+		ifd0 = append(ifd0, testEntry{tagGPSIFD, typeLong, 1, []uint32{0}})
+	}
+	pointers := map[uint16]int{}
+	offsets := map[uint16]uint32{}
+	for i, ifd := range [][]testEntry{ifd0, exifIFD, gpsIFD} {
+		if len(ifd) == 0 {
+			continue
+		}
+		offsets[[]uint16{0, tagExifIFD, tagGPSIFD}[i]] = uint32(buf.Len())
+		writeIFD(&buf, order, ifd, pointers)
+	}
+	data := buf.Bytes()
+	for tag, at := range pointers {
+		order.PutUint32(data[at:], offsets[tag])
+	}
+	return data
+}
+
+// writeIFD appends an IFD to buf, recording where each sub-IFD
+// pointer's value is in pointers
+func writeIFD(buf *bytes.Buffer, order binary.ByteOrder, entries []testEntry, pointers map[uint16]int) {
+	start := uint32(buf.Len())
+	valuesAt := start + 2 + uint32(len(entries))*12 + 4
+	var values bytes.Buffer
+
+	binary.Write(buf, order, uint16(len(entries)))
+	for _, e := range entries {
+		var v bytes.Buffer
+		switch value := e.value.(type) {
+		case string:
+			v.WriteString(value)
+		default:
+			binary.Write(&v, order, value)
+		}
+		binary.Write(buf, order, e.tag)
+		binary.Write(buf, order, e.typ)
+		binary.Write(buf, order, e.count)
+		if e.tag == tagExifIFD || e.tag == tagGPSIFD {
+			pointers[e.tag] = buf.Len()
+		}
+		if v.Len() <= 4 {
+			buf.Write(append(v.Bytes(), make([]byte, 4-v.Len())...))
+		} else {
+			binary.Write(buf, order, valuesAt+uint32(values.Len()))
+			values.Write(v.Bytes())
+		}
+	}
+	binary.Write(buf, order, uint32(0)) // no next IFD
+	buf.Write(values.Bytes())
+}
+
+// withExif returns a small JPEG with an APP1 segment holding tiff
+func withExif(t *testing.T, tiff []byte) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("jpeg.Encode(): expected nil, got \"%v\"", err)
+	}
+	segment := append([]byte(exifHeader), tiff...)
+	var buf bytes.Buffer
+	buf.Write(img.Bytes()[:2]) // SOI
+	// an XMP segment before it should be skipped
+	xmp := "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"
+	buf.Write([]byte{0xFF, markerAPP1})
+	binary.Write(&buf, binary.BigEndian, uint16(len(xmp)+2))
+	buf.WriteString(xmp)
+	buf.Write([]byte{0xFF, markerAPP1})
+	binary.Write(&buf, binary.BigEndian, uint16(len(segment)+2))
+	buf.Write(segment)
+	buf.Write(img.Bytes()[2:])
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	ifd0 := []testEntry{
+		{tagMake, typeASCII, 6, "Canon\x00"},
+		{tagModel, typeASCII, 20, "Canon EOS 5D Mark IV"},
+		{tagOrientation, typeShort, 1, []uint16{6}},
+	}
+	exifIFD := []testEntry{
+		{tagExposureTime, typeRational, 1, []uint32{1, 250}},
+		{tagFNumber, typeRational, 1, []uint32{28, 10}},
+		{tagISO, typeShort, 1, []uint16{400}},
+		{tagDateTimeOriginal, typeASCII, 20, "2019:07:14 16:32:05\x00"},
+		{tagFocalLength, typeRational, 1, []uint32{50, 1}},
+		{tagLensModel, typeASCII, 16, "EF50mm f/1.8 STM"},
+	}
+	gpsIFD := []testEntry{
+		{tagGPSLatitudeRef, typeASCII, 2, "N\x00"},
+		{tagGPSLatitude, typeRational, 3, []uint32{37, 1, 46, 1, 2940, 100}},
+		{tagGPSLongitudeRef, typeASCII, 2, "W\x00"},
+		{tagGPSLongitude, typeRational, 3, []uint32{122, 1, 25, 1, 900, 100}},
+		{tagGPSAltitudeRef, typeByte, 1, []byte{0}},
+		{tagGPSAltitude, typeRational, 1, []uint32{1525, 10}},
+	}
+
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		data := withExif(t, buildTIFF(order, ifd0, exifIFD, gpsIFD))
+		x, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Decode(%v): expected nil, got \"%v\"", order, err)
+		}
+		if x.Make != "Canon" || x.Model != "Canon EOS 5D Mark IV" || x.LensModel != "EF50mm f/1.8 STM" {
+			t.Errorf("Decode(%v): expected Canon, Canon EOS 5D Mark IV, EF50mm f/1.8 STM, got %q, %q, %q",
+				order, x.Make, x.Model, x.LensModel)
+		}
+		if x.Orientation != 6 || x.ISO != 400 {
+			t.Errorf("Decode(%v): expected orientation 6 and ISO 400, got %d and %d", order, x.Orientation, x.ISO)
+		}
+		if x.ExposureTime != (Rational{1, 250}) || x.FNumber.Float() != 2.8 || x.FocalLength.Float() != 50 {
+			t.Errorf("Decode(%v): expected 1/250 s at f/2.8 and 50 mm, got %v s at f/%v and %v mm",
+				order, x.ExposureTime, x.FNumber.Float(), x.FocalLength.Float())
+		}
+		if taken := time.Date(2019, 7, 14, 16, 32, 5, 0, time.UTC); !x.Taken.Equal(taken) {
+			t.Errorf("Decode(%v): expected taken at %v, got %v", order, taken, x.Taken)
+		}
+		if x.GPS == nil {
+			t.Fatalf("Decode(%v): expected a GPS position, got nil", order)
+		}
+		if math.Abs(x.GPS.Latitude-37.774833) > 1e-6 || math.Abs(x.GPS.Longitude+122.4192) > 1e-4 ||
+			!x.GPS.HasAltitude || x.GPS.Altitude != 152.5 {
+			t.Errorf("Decode(%v): expected 37.774833, -122.4192 at 152.5 m, got %+v", order, x.GPS)
+		}
+	}
+}
+
+func TestDecodePartial(t *testing.T) {
+	// only IFD0, with an out of range orientation
+	data := withExif(t, buildTIFF(binary.BigEndian, []testEntry{
+		{tagModel, typeASCII, 8, "Pixel 3\x00"},
+		{tagOrientation, typeShort, 1, []uint16{9}},
+	}, nil, nil))
+	x, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode(): expected nil, got \"%v\"", err)
+	}
+	if x.Model != "Pixel 3" || x.Orientation != 0 || x.GPS != nil || !x.Taken.IsZero() {
+		t.Errorf("Decode(): expected only the model, got %+v", x)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	var plain bytes.Buffer
+	jpeg.Encode(&plain, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+
+	valid := buildTIFF(binary.LittleEndian, []testEntry{
+		{tagOrientation, typeShort, 1, []uint16{3}},
+	}, nil, nil)
+	looped := buildTIFF(binary.LittleEndian, []testEntry{
+		{tagOrientation, typeShort, 1, []uint16{3}},
+	}, []testEntry{{tagISO, typeShort, 1, []uint16{100}}}, nil)
+	// point the Exif IFD entry, the second in IFD0, back at IFD0
+	binary.LittleEndian.PutUint32(looped[8+2+12+8:], 8)
+
+	var tests = []struct {
+		desc string
+		data []byte
+		err  error
+	}{
+		{"not a JPEG", []byte("GIF89a......"), ErrFormat},
+		{"empty", nil, ErrFormat},
+		{"no EXIF", plain.Bytes(), ErrNotFound},
+		{"truncated", withExif(t, valid)[:30], ErrFormat},
+		{"bad byte order", withExif(t, append([]byte("XX"), valid[2:]...)), ErrFormat},
+		{"IFD out of range", withExif(t, append(valid[:4:4], 0xFF, 0xFF, 0, 0)), ErrFormat},
+	}
+	for _, test := range tests {
+		if _, err := Decode(bytes.NewReader(test.data)); err != test.err {
+			t.Errorf("Decode(%s): expected \"%v\", got \"%v\"", test.desc, test.err, err)
+		}
+	}
+
+	// an IFD that points back to one already read is ignored
+	x, err := Decode(bytes.NewReader(withExif(t, looped)))
+	if err != nil || x.Orientation != 3 || x.ISO != 0 {
+		t.Errorf("Decode(looped): expected orientation 3 and no ISO, got %+v, \"%v\"", x, err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/password"
+	"github.com/peterpla/webdevgo/storage"
 )
 
 const (
@@ -19,13 +21,14 @@ func main() {
 		psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
 		"dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbName)
-	services, err := models.NewServices(psqlInfo)
+	services, err := models.NewServices(psqlInfo, models.DefaultKeys(), password.DefaultPolicy(), storage.Dir("images"))
 	if err != nil {
 		panic(err)
 	}
 	defer services.User.Close()
 	// services.User.AutoMigrate()
 	services.User.DestructiveReset()
+	services.Session.DestructiveReset()
 
 	user := models.User{
 		Name:     "Michael Scott",
@@ -38,18 +41,27 @@ func main() {
 		panic(err)
 	}
 
-	// Verify that the user object has its Remember and RememberHash
-	// set during Create()
 	fmt.Printf("User: %+v\n", user)
-	if user.Remember == "" {
+
+	// sign the user in on a "device", and verify the session has
+	// a remember token set during Create()
+	session := models.Session{
+		UserID:    user.ID,
+		UserAgent: "exp",
+	}
+	err = services.Session.Create(&session)
+	if err != nil {
+		panic(err)
+	}
+	if session.Token == "" {
 		panic("Invalid remember token")
 	}
 
 	// Verify we can lookup a user with that remember token
-	user2, err := services.User.ByRemember(user.Remember)
+	user2, err := services.User.ByRemember(session.Token)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("User2: %+v\n", user2)
 
-}
+}
\ No newline at end of file
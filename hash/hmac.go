@@ -5,28 +5,117 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"hash"
+	"strings"
+	"sync"
 )
 
+// tagSep separates a digest's key ID from the digest itself. It is
+// not in the base64 URL alphabet, so can't appear in a digest.
+const tagSep = "$"
+
 // HMAC is a wrapper around the crypto/hmac package
-// making it a little easier to use in our code
+// making it a little easier to use in our code.
+// It is safe for concurrent use.
 type HMAC struct {
-	hmac hash.Hash
+	keys []keyedHMAC // current first
+}
+
+// keyedHMAC is the HMAC for a single key. A hash.Hash holds state
+// between Write and Sum, so each call takes one from the pool rather
+// than sharing a single instance.
+type keyedHMAC struct {
+	id   string
+	pool *sync.Pool
+}
+
+// newKeyedHMAC returns a keyedHMAC for the provided key
+func newKeyedHMAC(id, secret string) keyedHMAC {
+	key := []byte(secret)
+	return keyedHMAC{
+		id: id,
+		pool: &sync.Pool{
+			New: func() interface{} {
+				return hmac.New(sha256.New, key)
+			},
+		},
+	}
 }
 
-// NewHMAC creates and returns a new HMAC object
+// NewHMAC creates and returns a new HMAC object with a single key,
+// whose digests are untagged
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
 	return HMAC{
-		hmac: h,
+		keys: []keyedHMAC{newKeyedHMAC("", key)},
 	}
 }
 
+// NewKeyedHMAC creates and returns a new HMAC object which hashes
+// with the keyring's current key, and can reproduce the digests made
+// by its older keys
+func NewKeyedHMAC(kr *Keyring) HMAC {
+	var h HMAC
+	for _, k := range kr.Keys() {
+		h.keys = append(h.keys, newKeyedHMAC(k.ID, k.Secret))
+	}
+	return h
+}
+
 // Hash will hash the provided input string using HMAC
-// with the secret key provided when the HMAC object
-// was created
+// with the current key. The digest is tagged with the
+// key's ID, if it has one.
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
-	return base64.URLEncoding.EncodeToString(b)
+	return h.keys[0].hash(input)
+}
+
+// Hashes returns the digest of input made with each key, current
+// first. Use it to look up a stored digest that may have been made
+// before the current key was added.
+func (h HMAC) Hashes(input string) []string {
+	digests := make([]string, len(h.keys))
+	for i, k := range h.keys {
+		digests[i] = k.hash(input)
+	}
+	return digests
+}
+
+// Verify reports whether digest is the digest of input made with
+// any of the keys. The comparisons take constant time.
+func (h HMAC) Verify(input, digest string) bool {
+	ok := false
+	for _, k := range h.keys {
+		// check every key, so the time taken doesn't reveal which matched
+		if Equal(k.hash(input), digest) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// Equal compares two digests in constant time, so the time taken
+// doesn't reveal how much of a guessed digest is correct
+func Equal(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+// IsCurrent reports whether digest was made with the current key
+func (h HMAC) IsCurrent(digest string) bool {
+	id := ""
+	if i := strings.Index(digest, tagSep); i >= 0 {
+		id = digest[:i]
+	}
+	return id == h.keys[0].id
+}
+
+// hash returns the tagged digest of input
+func (k keyedHMAC) hash(input string) string {
+	mac := k.pool.Get().(hash.Hash)
+	defer k.pool.Put(mac)
+	mac.Reset()
+	mac.Write([]byte(input))
+	b := mac.Sum(nil)
+	digest := base64.URLEncoding.EncodeToString(b)
+	if k.id == "" {
+		return digest
+	}
+	return k.id + tagSep + digest
 }
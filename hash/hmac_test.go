@@ -0,0 +1,139 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyedHMAC(t *testing.T) {
+	legacy := Key{Secret: "secret-hmac-key"}
+	v2 := Key{ID: "v2", Secret: "newer-secret"}
+
+	// a keyring holding only an unversioned key hashes exactly as a
+	// single-key HMAC does
+	kr, err := NewKeyring(legacy)
+	if err != nil {
+		t.Fatalf("NewKeyring(): expected nil, got \"%v\"", err)
+	}
+	old := NewHMAC(legacy.Secret).Hash("token")
+	if got := NewKeyedHMAC(kr).Hash("token"); got != old {
+		t.Errorf("Hash(unversioned key): expected %q, got %q", old, got)
+	}
+
+	kr, err = NewKeyring(v2, legacy)
+	if err != nil {
+		t.Fatalf("NewKeyring(): expected nil, got \"%v\"", err)
+	}
+	h := NewKeyedHMAC(kr)
+
+	digest := h.Hash("token")
+	if !strings.HasPrefix(digest, "v2$") {
+		t.Errorf("Hash(): expected \"v2$\" prefix, got %q", digest)
+	}
+	if !h.IsCurrent(digest) || h.IsCurrent(old) {
+		t.Errorf("IsCurrent(): expected true for %q and false for %q", digest, old)
+	}
+
+	digests := h.Hashes("token")
+	if len(digests) != 2 || digests[0] != digest || digests[1] != old {
+		t.Errorf("Hashes(): expected [%q %q], got %q", digest, old, digests)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	kr, err := ParseKeyring(" v2:new:secret , v1:old-secret,legacy-secret")
+	if err != nil {
+		t.Fatalf("ParseKeyring(): expected nil, got \"%v\"", err)
+	}
+	want := []Key{
+		{ID: "v2", Secret: "new:secret"},
+		{ID: "v1", Secret: "old-secret"},
+		{Secret: "legacy-secret"},
+	}
+	got := kr.Keys()
+	if len(got) != len(want) {
+		t.Fatalf("Keys(): expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Keys()[%d]: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+	if kr.Current() != want[0] {
+		t.Errorf("Current(): expected %v, got %v", want[0], kr.Current())
+	}
+	if k, ok := kr.Key("v1"); !ok || k != want[1] {
+		t.Errorf("Key(\"v1\"): expected %v, true, got %v, %v", want[1], k, ok)
+	}
+
+	for _, bad := range []string{
+		"",
+		" , ",
+		"v1:",
+		"v1:a,v1:b",
+		"v$1:secret",
+	} {
+		if _, err := ParseKeyring(bad); err == nil {
+			t.Errorf("ParseKeyring(%q): expected error, got nil", bad)
+		}
+	}
+}
+
+func TestHMACVerifyAndEqual(t *testing.T) {
+	kr, _ := NewKeyring(Key{ID: "v2", Secret: "newer-secret"}, Key{Secret: "secret-hmac-key"})
+	h := NewKeyedHMAC(kr)
+
+	for _, digest := range h.Hashes("token") {
+		if !h.Verify("token", digest) {
+			t.Errorf("Verify(\"token\", %q): expected true", digest)
+		}
+		if h.Verify("other", digest) {
+			t.Errorf("Verify(\"other\", %q): expected false", digest)
+		}
+	}
+	if h.Verify("token", "") {
+		t.Errorf("Verify(\"token\", \"\"): expected false")
+	}
+
+	if !Equal("abc", "abc") || Equal("abc", "abd") || Equal("abc", "ab") {
+		t.Errorf("Equal(): expected true only for identical strings")
+	}
+}
+
+// TestHMACConcurrent hashes from many goroutines at once; run it with
+// -race. With a shared hash.Hash, digests came out wrong.
+func TestHMACConcurrent(t *testing.T) {
+	kr, _ := NewKeyring(Key{ID: "v2", Secret: "newer-secret"}, Key{Secret: "secret-hmac-key"})
+	h := NewKeyedHMAC(kr)
+
+	inputs := []string{"alpha", "bravo", "charlie", "delta"}
+	want := make(map[string]string, len(inputs))
+	for _, in := range inputs {
+		want[in] = h.Hash(in)
+	}
+
+	const goroutines = 16
+	const iterations = 500
+	errs := make(chan string, goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			for i := 0; i < iterations; i++ {
+				in := inputs[(g+i)%len(inputs)]
+				if got := h.Hash(in); got != want[in] {
+					errs <- "Hash(" + in + "): expected " + want[in] + ", got " + got
+					return
+				}
+				if !h.Verify(in, want[in]) {
+					errs <- "Verify(" + in + "): expected true"
+					return
+				}
+			}
+			errs <- ""
+		}(g)
+	}
+	for g := 0; g < goroutines; g++ {
+		if msg := <-errs; msg != "" {
+			t.Error(msg)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package hash
+
+import (
+	"errors"
+	"strings"
+)
+
+// Key is a single versioned secret. Digests made with a key are tagged
+// with its ID, so the key can be found again after newer keys are
+// added. A key with an empty ID makes untagged digests, as keys did
+// before they were versioned.
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// Keyring is a set of keys, with the current key first. New digests
+// are always made with the current key; older keys are kept so that
+// values hashed with them can still be checked, and re-hashed with
+// the current key as they are used.
+type Keyring struct {
+	keys []Key
+}
+
+var (
+	errNoKeys       = errors.New("hash: keyring must have at least one key")
+	errEmptySecret  = errors.New("hash: key secret must not be empty")
+	errDuplicateKey = errors.New("hash: key IDs must be unique")
+	errBadKeyID     = errors.New("hash: key IDs may only contain letters, digits, '-' and '_'")
+)
+
+// NewKeyring returns a Keyring whose current key is current
+func NewKeyring(current Key, older ...Key) (*Keyring, error) {
+	keys := append([]Key{current}, older...)
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Secret == "" {
+			return nil, errEmptySecret
+		}
+		if !validKeyID(k.ID) {
+			return nil, errBadKeyID
+		}
+		if seen[k.ID] {
+			return nil, errDuplicateKey
+		}
+		seen[k.ID] = true
+	}
+	return &Keyring{keys: keys}, nil
+}
+
+// ParseKeyring parses a keyring from configuration, in the form
+//
+//	id:secret,id:secret,...
+//
+// with the current key first. A secret may contain ':' but not ','.
+// An entry with no "id:" is a key with an empty ID.
+func ParseKeyring(s string) (*Keyring, error) {
+	var keys []Key
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var k Key
+		if i := strings.Index(entry, ":"); i >= 0 {
+			k.ID, k.Secret = entry[:i], entry[i+1:]
+		} else {
+			k.Secret = entry
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, errNoKeys
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// Current returns the key used for new digests
+func (kr *Keyring) Current() Key {
+	return kr.keys[0]
+}
+
+// Keys returns every key, current first
+func (kr *Keyring) Keys() []Key {
+	return append([]Key(nil), kr.keys...)
+}
+
+// Key returns the key with the provided ID
+func (kr *Keyring) Key(id string) (Key, bool) {
+	for _, k := range kr.keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// validKeyID ensures an ID can't be confused with the digest or
+// hash it tags
+func validKeyID(id string) bool {
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package hash
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// TokenNonceBytes is the length of the random nonce added to each
+// token, so no two tokens are the same even with identical claims
+const TokenNonceBytes = 8
+
+// TokenError is returned when a token can't be decoded. The errors
+// are distinct so callers can log why a token was refused, but most
+// should treat them all alike when responding to the user.
+type TokenError string
+
+func (e TokenError) Error() string {
+	return string(e)
+}
+
+const (
+	// ErrTokenMalformed is returned for tokens that can't be parsed
+	ErrTokenMalformed TokenError = "hash: token is malformed"
+
+	// ErrTokenSignature is returned for tokens whose signature does
+	// not match their claims, under any key
+	ErrTokenSignature TokenError = "hash: token signature is invalid"
+
+	// ErrTokenPurpose is returned for tokens issued for some other
+	// purpose than the one expected
+	ErrTokenPurpose TokenError = "hash: token has the wrong purpose"
+
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired TokenError = "hash: token has expired"
+)
+
+// Claims are the contents of a signed token. Tokens are signed but
+// not encrypted: don't put anything secret in them.
+type Claims struct {
+	// Purpose names what the token is for, e.g. "verify", so a token
+	// issued for one purpose can't be used for another
+	Purpose string `json:"p"`
+
+	// Subject is the ID of whatever the token is about, usually a user
+	Subject uint `json:"s,omitempty"`
+
+	// Data is optional extra payload
+	Data string `json:"d,omitempty"`
+
+	// Expires is when the token stops being valid
+	Expires time.Time `json:"-"`
+
+	// Nonce is filled in by Encode
+	Nonce []byte `json:"n"`
+}
+
+// wireClaims is Claims as serialized, with the expiry in Unix seconds
+type wireClaims struct {
+	Claims
+	Exp int64 `json:"e"`
+}
+
+// TokenCodec encodes and decodes signed, expiring tokens. Tokens are
+// signed with the HMAC's current key, and verified against any of
+// its keys. It is safe for concurrent use.
+type TokenCodec struct {
+	hmac HMAC
+}
+
+// NewTokenCodec returns a TokenCodec that signs tokens with h
+func NewTokenCodec(h HMAC) *TokenCodec {
+	return &TokenCodec{hmac: h}
+}
+
+// Encode returns a token carrying claims, with a new random nonce.
+// The token is URL and cookie safe.
+func (tc *TokenCodec) Encode(claims Claims) (string, error) {
+	if claims.Purpose == "" || claims.Expires.IsZero() {
+		return "", ErrTokenMalformed
+	}
+	nonce, err := rand.Bytes(TokenNonceBytes)
+	if err != nil {
+		return "", err
+	}
+	claims.Nonce = nonce
+
+	b, err := json.Marshal(wireClaims{Claims: claims, Exp: claims.Expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + tc.hmac.Hash(payload), nil
+}
+
+// Decode checks the token's signature, purpose and expiry and returns
+// its claims. Any problem results in one of the TokenError values.
+func (tc *TokenCodec) Decode(token, purpose string) (*Claims, error) {
+	i := strings.Index(token, ".")
+	if i < 0 {
+		return nil, ErrTokenMalformed
+	}
+	payload, sig := token[:i], token[i+1:]
+	if !tc.hmac.Verify(payload, sig) {
+		return nil, ErrTokenSignature
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var wc wireClaims
+	if err := json.Unmarshal(b, &wc); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := wc.Claims
+	claims.Expires = time.Unix(wc.Exp, 0)
+
+	if claims.Purpose != purpose {
+		return nil, ErrTokenPurpose
+	}
+	if time.Now().After(claims.Expires) {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
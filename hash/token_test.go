@@ -0,0 +1,84 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenCodec(t *testing.T) {
+	tc := NewTokenCodec(NewHMAC("secret-hmac-key"))
+	expires := time.Now().Add(time.Hour)
+
+	claims := Claims{Purpose: "verify", Subject: 42, Data: "bozo@clown.net", Expires: expires}
+	token, err := tc.Encode(claims)
+	if err != nil {
+		t.Fatalf("Encode(): expected nil, got \"%v\"", err)
+	}
+	got, err := tc.Decode(token, "verify")
+	if err != nil {
+		t.Fatalf("Decode(): expected nil, got \"%v\"", err)
+	}
+	if got.Purpose != "verify" || got.Subject != 42 || got.Data != "bozo@clown.net" ||
+		got.Expires.Unix() != expires.Unix() || len(got.Nonce) != TokenNonceBytes {
+		t.Errorf("Decode(): expected %+v, got %+v", claims, got)
+	}
+
+	// identical claims still make different tokens
+	again, _ := tc.Encode(claims)
+	if again == token {
+		t.Errorf("Encode() twice: expected different tokens, got %q both times", token)
+	}
+
+	expired, _ := tc.Encode(Claims{Purpose: "verify", Subject: 42, Expires: time.Now().Add(-time.Second)})
+	otherKey, _ := NewTokenCodec(NewHMAC("other-key")).Encode(claims)
+	payload := token[:strings.Index(token, ".")]
+	sig := token[strings.Index(token, ".")+1:]
+	tampered := strings.TrimSuffix(payload, payload[len(payload)-2:]) + "xx." + sig
+
+	tests := []struct {
+		name    string
+		token   string
+		purpose string
+		want    error
+	}{
+		{"empty", "", "verify", ErrTokenMalformed},
+		{"no signature", payload, "verify", ErrTokenMalformed},
+		{"tampered", tampered, "verify", ErrTokenSignature},
+		{"other key", otherKey, "verify", ErrTokenSignature},
+		{"signed garbage", "bm90LWpzb24." + NewHMAC("secret-hmac-key").Hash("bm90LWpzb24"), "verify", ErrTokenMalformed},
+		{"wrong purpose", token, "reset", ErrTokenPurpose},
+		{"expired", expired, "verify", ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		if _, err := tc.Decode(tt.token, tt.purpose); err != tt.want {
+			t.Errorf("%s: Decode(): expected \"%v\", got \"%v\"", tt.name, tt.want, err)
+		}
+	}
+
+	if _, err := tc.Encode(Claims{Purpose: "verify"}); err != ErrTokenMalformed {
+		t.Errorf("Encode(no expiry): expected \"%v\", got \"%v\"", ErrTokenMalformed, err)
+	}
+}
+
+func TestTokenCodecKeyRotation(t *testing.T) {
+	legacy := Key{Secret: "secret-hmac-key"}
+	v2 := Key{ID: "v2", Secret: "rotated-hmac-key"}
+
+	before, _ := NewKeyring(legacy)
+	during, _ := NewKeyring(v2, legacy)
+	after, _ := NewKeyring(v2)
+
+	claims := Claims{Purpose: "verify", Subject: 42, Expires: time.Now().Add(time.Hour)}
+	token, _ := NewTokenCodec(NewKeyedHMAC(before)).Encode(claims)
+
+	// still accepted while the old key is in the keyring
+	if _, err := NewTokenCodec(NewKeyedHMAC(during)).Decode(token, "verify"); err != nil {
+		t.Errorf("Decode(during rotation): expected nil, got \"%v\"", err)
+	}
+
+	// and rejected once it is retired
+	if _, err := NewTokenCodec(NewKeyedHMAC(after)).Decode(token, "verify"); err != ErrTokenSignature {
+		t.Errorf("Decode(after rotation): expected \"%v\", got \"%v\"", ErrTokenSignature, err)
+	}
+}
@@ -0,0 +1,47 @@
+package imaging
+
+import "image"
+
+// Orient returns src transformed to display the right way up, given
+// its EXIF orientation: 1 is as stored, 2-4 are flips and a half
+// turn, and 5-8 also swap the width and height. Other values return
+// src unchanged.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	rgba := toRGBA(src)
+	b := rgba.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// for each pixel of dst, find the pixel of src it comes from
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flipped horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated a half turn
+				sx, sy = w-1-x, h-1-y
+			case 4: // flipped vertically
+				sx, sy = x, h-1-y
+			case 5: // flipped about the top-left to bottom-right diagonal
+				sx, sy = y, x
+			case 6: // needs turning clockwise
+				sx, sy = y, h-1-x
+			case 7: // flipped about the top-right to bottom-left diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs turning counterclockwise
+				sx, sy = w-1-y, x
+			}
+			i := rgba.PixOffset(b.Min.X+sx, b.Min.Y+sy)
+			copy(dst.Pix[dst.PixOffset(x, y):], rgba.Pix[i:i+4])
+		}
+	}
+	return dst
+}
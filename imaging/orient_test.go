@@ -0,0 +1,60 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	// a 3x2 image whose pixels are numbered in reading order:
+	//   1 2 3
+	//   4 5 6
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Set(i%3, i/3, color.RGBA{uint8(i + 1), 0, 0, 255})
+	}
+
+	// each orientation's stored pixels, and the expected result, read
+	// row by row
+	var tests = []struct {
+		orientation int
+		expected    []uint8
+		width       int
+	}{
+		{1, []uint8{1, 2, 3, 4, 5, 6}, 3},
+		{2, []uint8{3, 2, 1, 6, 5, 4}, 3},
+		{3, []uint8{6, 5, 4, 3, 2, 1}, 3},
+		{4, []uint8{4, 5, 6, 1, 2, 3}, 3},
+		{5, []uint8{1, 4, 2, 5, 3, 6}, 2},
+		{6, []uint8{4, 1, 5, 2, 6, 3}, 2},
+		{7, []uint8{6, 3, 5, 2, 4, 1}, 2},
+		{8, []uint8{3, 6, 2, 5, 1, 4}, 2},
+		{0, []uint8{1, 2, 3, 4, 5, 6}, 3},
+	}
+
+	for _, test := range tests {
+		dst := Orient(src, test.orientation)
+		b := dst.Bounds()
+		if b.Dx() != test.width || b.Dx()*b.Dy() != 6 {
+			t.Errorf("Orient(%d): expected width %d, got %v", test.orientation, test.width, b)
+			continue
+		}
+		var got []uint8
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, _, _, _ := dst.At(x, y).RGBA()
+				got = append(got, uint8(r>>8))
+			}
+		}
+		if string(got) != string(test.expected) {
+			t.Errorf("Orient(%d): expected %v, got %v", test.orientation, test.expected, got)
+		}
+	}
+
+	// sub-images are read from their own origin
+	sub := src.SubImage(image.Rect(1, 0, 3, 2))
+	if r, _, _, _ := Orient(sub, 3).At(0, 0).RGBA(); r>>8 != 6 {
+		t.Errorf("Orient(sub-image): expected pixel 6 first, got %d", r>>8)
+	}
+}
@@ -0,0 +1,195 @@
+// Package imaging resizes images using only the standard library.
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// filterRadius is the support of the Catmull-Rom filter, in source
+// pixels when enlarging and destination pixels when shrinking
+const filterRadius = 2
+
+// catmullRom is the Catmull-Rom cubic filter: sharp, with little
+// ringing, and exact for images that aren't resized
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	default:
+		return 0
+	}
+}
+
+// Resize returns src scaled to exactly width x height pixels. Colors
+// are filtered with alpha premultiplied, so transparent pixels don't
+// bleed their color into their neighbours.
+func Resize(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	if width <= 0 || height <= 0 || b.Empty() {
+		return dst
+	}
+
+	rgba := toRGBA(src)
+	origin := rgba.Bounds().Min
+	xWeights := weights(b.Dx(), width)
+	yWeights := weights(b.Dy(), height)
+
+	// resize horizontally into tmp, one row of the source at a time,
+	// then vertically from tmp into dst
+	tmp := make([]float64, b.Dy()*width*4)
+	for y := 0; y < b.Dy(); y++ {
+		row := rgba.Pix[rgba.PixOffset(origin.X, origin.Y+y):]
+		out := tmp[y*width*4:]
+		for x, ws := range xWeights {
+			var r, g, bl, a float64
+			for i, w := range ws.values {
+				p := row[(ws.first+i)*4:]
+				r += w * float64(p[0])
+				g += w * float64(p[1])
+				bl += w * float64(p[2])
+				a += w * float64(p[3])
+			}
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = r, g, bl, a
+		}
+	}
+
+	for y, ws := range yWeights {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var r, g, bl, a float64
+			for i, w := range ws.values {
+				p := tmp[((ws.first+i)*width+x)*4:]
+				r += w * p[0]
+				g += w * p[1]
+				bl += w * p[2]
+				a += w * p[3]
+			}
+			// premultiplied colors can't exceed alpha
+			alpha := clamp(a, 255)
+			out[x*4] = uint8(clamp(r, float64(alpha)))
+			out[x*4+1] = uint8(clamp(g, float64(alpha)))
+			out[x*4+2] = uint8(clamp(bl, float64(alpha)))
+			out[x*4+3] = alpha
+		}
+	}
+	return dst
+}
+
+// Fit returns src scaled down, keeping its aspect ratio, to fit
+// within maxWidth x maxHeight. Images that already fit are returned
+// as they are. A limit of zero or less leaves that dimension free.
+func Fit(src image.Image, maxWidth, maxHeight int) image.Image {
+	b := src.Bounds()
+	w, h := FitSize(b.Dx(), b.Dy(), maxWidth, maxHeight)
+	if w == b.Dx() && h == b.Dy() {
+		return src
+	}
+	return Resize(src, w, h)
+}
+
+// FitSize returns the size Fit scales a width x height image to
+func FitSize(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && float64(height)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(height)
+	}
+	if scale == 1 {
+		return width, height
+	}
+	return scaled(width, scale), scaled(height, scale)
+}
+
+// scaled returns n*scale rounded, but at least 1
+func scaled(n int, scale float64) int {
+	s := int(math.Round(float64(n) * scale))
+	if s < 1 {
+		return 1
+	}
+	return s
+}
+
+// clamp rounds v to the nearest integer from 0 to max
+func clamp(v, max float64) uint8 {
+	v = math.Round(v)
+	switch {
+	case v < 0:
+		return 0
+	case v > max:
+		return uint8(max)
+	default:
+		return uint8(v)
+	}
+}
+
+// toRGBA returns src as an *image.RGBA, converting it if necessary
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok {
+		return rgba
+	}
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	return rgba
+}
+
+// pixelWeights are the filter weights of the source pixels that make
+// up one destination pixel, starting at source pixel first
+type pixelWeights struct {
+	first  int
+	values []float64
+}
+
+// weights returns the filter weights for each of dstLen pixels
+// resampled from srcLen pixels. Pixels past the edges are treated as
+// copies of the edge pixels.
+func weights(srcLen, dstLen int) []pixelWeights {
+	scale := float64(srcLen) / float64(dstLen)
+	// widen the filter when shrinking, so every source pixel counts
+	filterScale := math.Max(scale, 1)
+	support := filterRadius * filterScale
+
+	ws := make([]pixelWeights, dstLen)
+	for i := range ws {
+		center := (float64(i)+0.5)*scale - 0.5
+		first := int(math.Ceil(center - support))
+		last := int(math.Floor(center + support))
+
+		// fold the weights of pixels past the edges onto the edges
+		lo, hi := clampInt(first, srcLen), clampInt(last, srcLen)
+		values := make([]float64, hi-lo+1)
+		var sum float64
+		for j := first; j <= last; j++ {
+			w := catmullRom((float64(j) - center) / filterScale)
+			values[clampInt(j, srcLen)-lo] += w
+			sum += w
+		}
+		if sum != 0 {
+			for k := range values {
+				values[k] /= sum
+			}
+		}
+		ws[i] = pixelWeights{first: lo, values: values}
+	}
+	return ws
+}
+
+// clampInt returns i limited to the indexes of a slice of length n
+func clampInt(i, n int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i >= n:
+		return n - 1
+	default:
+		return i
+	}
+}
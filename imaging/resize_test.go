@@ -0,0 +1,96 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	// a solid image stays solid at any size
+	solid := image.NewNRGBA(image.Rect(10, 10, 47, 29))
+	for i := 0; i < len(solid.Pix); i += 4 {
+		copy(solid.Pix[i:], []uint8{200, 100, 50, 255})
+	}
+	for _, size := range []image.Point{{10, 5}, {37, 19}, {80, 41}, {1, 1}} {
+		dst := Resize(solid, size.X, size.Y)
+		if dst.Bounds().Size() != size {
+			t.Fatalf("Resize(%v): expected size %v, got %v", size, size, dst.Bounds().Size())
+		}
+		for i := 0; i < len(dst.Pix); i += 4 {
+			if got := dst.Pix[i : i+4]; got[0] != 200 || got[1] != 100 || got[2] != 50 || got[3] != 255 {
+				t.Fatalf("Resize(%v): expected every pixel [200 100 50 255], got %v", size, got)
+			}
+		}
+	}
+
+	// resizing to the same size changes nothing
+	gradient := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range gradient.Pix {
+		gradient.Pix[i] = uint8(i)
+		if i%4 == 3 {
+			gradient.Pix[i] = 255
+		}
+	}
+	if dst := Resize(gradient, 16, 16); string(dst.Pix) != string(gradient.Pix) {
+		t.Errorf("Resize(same size): expected the pixels unchanged")
+	}
+
+	// stripes one pixel wide average out when halved
+	stripes := image.NewGray(image.Rect(0, 0, 32, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 32; x += 2 {
+			stripes.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	dst := Resize(stripes, 16, 4)
+	for x := 2; x < 14; x++ {
+		if r := dst.RGBAAt(x, 2).R; r < 120 || r > 135 {
+			t.Errorf("Resize(stripes): expected pixel %d near 128, got %d", x, r)
+		}
+	}
+
+	// transparent pixels don't bleed their color
+	half := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			if x < 10 {
+				half.SetNRGBA(x, y, color.NRGBA{R: 255, A: 0})
+			} else {
+				half.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	dst = Resize(half, 7, 7)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if dst.Pix[i] != 0 {
+			t.Fatalf("Resize(transparent): expected no red, got %v", dst.Pix[i:i+4])
+		}
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	var tests = []struct {
+		w, h, maxW, maxH int
+		expW, expH       int
+	}{
+		{4000, 3000, 200, 200, 200, 150},
+		{3000, 4000, 200, 200, 150, 200},
+		{4000, 3000, 800, 0, 800, 600},
+		{4000, 3000, 0, 300, 400, 300},
+		{100, 50, 200, 200, 100, 50}, // never enlarged
+		{10000, 1, 100, 100, 100, 1}, // at least 1 pixel
+	}
+	for _, test := range tests {
+		w, h := FitSize(test.w, test.h, test.maxW, test.maxH)
+		if w != test.expW || h != test.expH {
+			t.Errorf("FitSize(%d, %d, %d, %d): expected %dx%d, got %dx%d",
+				test.w, test.h, test.maxW, test.maxH, test.expW, test.expH, w, h)
+		}
+	}
+
+	small := image.NewGray(image.Rect(0, 0, 10, 10))
+	if Fit(small, 20, 20) != image.Image(small) {
+		t.Errorf("Fit(): expected an image that fits to be returned as-is")
+	}
+}
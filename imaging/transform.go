@@ -0,0 +1,94 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Ways of fitting an image to a box
+const (
+	// FitContain scales the image down to fit within the box,
+	// keeping its aspect ratio. It never enlarges the image.
+	FitContain = "contain"
+
+	// FitCover scales the image to cover the box, keeping its aspect
+	// ratio, then crops the overflow equally from both sides
+	FitCover = "cover"
+)
+
+// Formats Encode can write
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+// jpegQuality is the quality JPEGs are encoded at
+const jpegQuality = 85
+
+// ErrFormat is returned by Encode for formats it can't write
+var ErrFormat = errors.New("imaging: unknown format")
+
+// Cover returns src scaled and cropped to exactly width x height
+// pixels, keeping the middle of the image
+func Cover(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	if width <= 0 || height <= 0 || b.Empty() {
+		return image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+
+	// crop the source to the box's aspect ratio first, so only the
+	// pixels that will be kept are resampled
+	crop := b
+	srcRatio := float64(b.Dx()) / float64(b.Dy())
+	dstRatio := float64(width) / float64(height)
+	if srcRatio > dstRatio {
+		w := int(math.Round(float64(b.Dy()) * dstRatio))
+		if w < 1 {
+			w = 1
+		}
+		crop.Min.X += (b.Dx() - w) / 2
+		crop.Max.X = crop.Min.X + w
+	} else if srcRatio < dstRatio {
+		h := int(math.Round(float64(b.Dx()) / dstRatio))
+		if h < 1 {
+			h = 1
+		}
+		crop.Min.Y += (b.Dy() - h) / 2
+		crop.Max.Y = crop.Min.Y + h
+	}
+
+	rgba := toRGBA(src)
+	offset := rgba.Bounds().Min.Sub(b.Min)
+	return Resize(rgba.SubImage(crop.Add(offset)), width, height)
+}
+
+// Transform fits src to a width x height box in the given way. A
+// width or height of zero leaves that dimension free; FitCover needs
+// both.
+func Transform(src image.Image, width, height int, fit string) image.Image {
+	if fit == FitCover && width > 0 && height > 0 {
+		return Cover(src, width, height)
+	}
+	return Fit(src, width, height)
+}
+
+// Encode writes img to w in the given format. GIFs are reduced to a
+// 256 color palette.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	default:
+		return ErrFormat
+	}
+}
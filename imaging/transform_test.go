@@ -0,0 +1,88 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// bands returns a width x height image in three vertical bands: red,
+// green, then blue
+func bands(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width*2/3 {
+				c = color.RGBA{B: 255, A: 255}
+			} else if x >= width/3 {
+				c = color.RGBA{G: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestCover(t *testing.T) {
+	// a wide image is cropped to its middle band
+	dst := Cover(bands(300, 100), 50, 50)
+	if dst.Bounds().Dx() != 50 || dst.Bounds().Dy() != 50 {
+		t.Fatalf("Cover(): expected 50x50, got %v", dst.Bounds())
+	}
+	for _, x := range []int{0, 25, 49} {
+		if c := dst.RGBAAt(x, 25); c.G < 250 || c.R > 5 || c.B > 5 {
+			t.Errorf("Cover(wide): expected green at x=%d, got %v", x, c)
+		}
+	}
+
+	// a tall image keeps its full width, and works from a sub-image
+	tall := bands(90, 400).SubImage(image.Rect(0, 100, 90, 400))
+	dst = Cover(tall, 30, 60)
+	if c := dst.RGBAAt(2, 30); c.R < 250 {
+		t.Errorf("Cover(tall): expected red on the left, got %v", c)
+	}
+	if c := dst.RGBAAt(27, 30); c.B < 250 {
+		t.Errorf("Cover(tall): expected blue on the right, got %v", c)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	src := bands(300, 100)
+	var tests = []struct {
+		width, height int
+		fit           string
+		expected      image.Point
+	}{
+		{150, 150, FitContain, image.Pt(150, 50)},
+		{150, 150, FitCover, image.Pt(150, 150)},
+		{150, 0, FitCover, image.Pt(150, 50)}, // cover needs both
+		{0, 20, "", image.Pt(60, 20)},
+		{600, 600, FitContain, image.Pt(300, 100)}, // never enlarged
+	}
+	for _, test := range tests {
+		got := Transform(src, test.width, test.height, test.fit).Bounds().Size()
+		if got != test.expected {
+			t.Errorf("Transform(%d, %d, %q): expected %v, got %v",
+				test.width, test.height, test.fit, test.expected, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	src := bands(30, 10)
+	for _, format := range []string{FormatJPEG, FormatPNG, FormatGIF} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, format); err != nil {
+			t.Fatalf("Encode(%s): expected nil, got \"%v\"", format, err)
+		}
+		img, got, err := image.Decode(&buf)
+		if err != nil || got != format || img.Bounds() != src.Bounds() {
+			t.Errorf("Encode(%s): expected a 30x10 %s, got %q, \"%v\"", format, format, got, err)
+		}
+	}
+	if err := Encode(&bytes.Buffer{}, src, "webp"); err != ErrFormat {
+		t.Errorf("Encode(webp): expected \"%v\", got \"%v\"", ErrFormat, err)
+	}
+}
@@ -4,9 +4,17 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"os"
 
 	"github.com/peterpla/webdevgo/controllers"
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/diskcache"
+	"github.com/peterpla/webdevgo/email"
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/middleware"
 	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/password"
+	"github.com/peterpla/webdevgo/storage"
 	"github.com/peterpla/webdevgo/views"
 
 	"github.com/gorilla/mux"
@@ -18,6 +26,48 @@ const (
 	dbUser = "postgres"
 	// password = "" // DO NOT use empty-string password when NO password is set!
 	dbName = "whatever_dev"
+
+	baseURL  = "http://localhost:3000"
+	mailFrom = "Whatever.com Support <support@whatever.com>"
+	// during development mail is written to this maildir rather than
+	// sent; use email.NewSMTP to deliver it for real
+	mailDir = "maildir"
+	// uploaded gallery images are stored under this directory,
+	// unless imagesDirEnv names another, or s3BucketEnv names an
+	// S3 bucket to keep them in instead
+	imagesDir    = "images"
+	imagesDirEnv = "WHATEVER_IMAGES_DIR"
+	// images transformed by the /img endpoint are cached under this
+	// directory, unless imageCacheDirEnv names another, using at
+	// most imageCacheBytes
+	imageCacheDir    = "imgcache"
+	imageCacheDirEnv = "WHATEVER_IMAGE_CACHE_DIR"
+	imageCacheBytes  = 256 << 20 // 256 MB
+
+	// the largest form the app accepts, other than image uploads
+	maxFormBytes = 1 << 20 // 1 MB
+
+	// keyrings are read from these environment variables, in the
+	// form "id:secret,id:secret" with the current key first. When
+	// unset, the development secrets are used.
+	hmacKeysEnv   = "WHATEVER_HMAC_KEYS"
+	pepperKeysEnv = "WHATEVER_PASSWORD_PEPPERS"
+
+	// a directory of Pwned Passwords range files (see
+	// password.RangeDir); when unset, only the bundled list of
+	// common passwords is refused
+	breachedPwDirEnv = "WHATEVER_BREACHED_PASSWORDS_DIR"
+
+	// set to any value when running behind a proxy that terminates
+	// TLS and sets X-Forwarded-Proto, so cookies are still Secure
+	trustProxyEnv = "WHATEVER_TRUST_PROXY"
+
+	// S3-compatible object storage for images (see storage.S3)
+	s3EndpointEnv  = "WHATEVER_S3_ENDPOINT"
+	s3RegionEnv    = "WHATEVER_S3_REGION"
+	s3BucketEnv    = "WHATEVER_S3_BUCKET"
+	s3AccessKeyEnv = "WHATEVER_S3_ACCESS_KEY_ID"
+	s3SecretKeyEnv = "WHATEVER_S3_SECRET_ACCESS_KEY"
 )
 
 var homeView *views.View
@@ -36,7 +86,15 @@ func main() {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
 		"dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbName)
-	services, err := models.NewServices(psqlInfo)
+	keys, err := loadKeys()
+	if err != nil {
+		panic(err)
+	}
+	policy := password.DefaultPolicy()
+	if dir := os.Getenv(breachedPwDirEnv); dir != "" {
+		policy.Corpora = append(policy.Corpora, password.RangeDir(dir))
+	}
+	services, err := models.NewServices(psqlInfo, keys, policy, imageStore())
 	if err != nil {
 		panic(err)
 	}
@@ -44,11 +102,70 @@ func main() {
 	defer services.User.Close()
 	// services.User.DestructiveReset()
 	services.User.AutoMigrate()
+	services.Session.AutoMigrate()
+	services.TwoFactor.AutoMigrate()
+	services.LoginThrottle.AutoMigrate()
+	services.Gallery.AutoMigrate()
+	services.Image.AutoMigrate()
+
+	mailer, err := email.NewMaildir(mailDir)
+	if err != nil {
+		panic(err)
+	}
+	emailer := email.NewClient(mailer, mailFrom, baseURL)
+
+	cacheDir := imageCacheDir
+	if dir := os.Getenv(imageCacheDirEnv); dir != "" {
+		cacheDir = dir
+	}
+	imageCache, err := diskcache.New(cacheDir, imageCacheBytes)
+	if err != nil {
+		panic(err)
+	}
+
+	// auth cookies are encrypted with the HMAC keyring, and get the
+	// "__Host-" prefix whenever they're Secure
+	cookies := cookie.Policy{
+		TrustProxy: os.Getenv(trustProxyEnv) != "",
+		HostPrefix: true,
+		Keys:       keys.HMAC,
+	}
+
+	r := mux.NewRouter()
 
 	// initialize controllers
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User)
-	// galleriesC := controllers.NewGalleries()
+	usersC := controllers.NewUsers(services.User, services.Session, services.TwoFactor, services.LoginThrottle, cookies, emailer)
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, r)
+	imagesC := controllers.NewImages(services.Image, hash.NewKeyedHMAC(keys.HMAC), imageCache)
+	views.ImageURL = imagesC.URL
+	sessionsC := controllers.NewSessions(services.Session, cookies)
+	twoFactorC := controllers.NewTwoFactor(services.User, services.TwoFactor, services.LoginThrottle)
+	errorsC := controllers.NewErrors()
+	adminC := controllers.NewAdmin(services.LoginThrottle)
+
+	// initialize middleware
+	userMw := middleware.User{
+		UserService: services.User,
+		Cookies:     cookies,
+	}
+	requireUserMw := middleware.RequireUser{
+		User: userMw,
+	}
+	requireVerifiedMw := middleware.RequireVerified{
+		RequireUser: requireUserMw,
+	}
+	requireAdminMw := middleware.RequireAdmin{
+		RequireUser: requireUserMw,
+	}
+	csrfMw := middleware.CSRF{
+		HMAC:    hash.NewKeyedHMAC(keys.HMAC),
+		Cookies: cookies,
+		Failure: http.HandlerFunc(errorsC.CSRF),
+
+		MaxBodyBytes:      maxFormBytes,
+		MaxMultipartBytes: models.ImageUploadMaxBytes,
+	}
 
 	// initialize views
 	// homeView = views.NewView("bootstrap", "static/home")
@@ -56,21 +173,102 @@ func main() {
 	// faqView = views.NewView("bootstrap", "static/faq")
 
 	// define routing
-	r := mux.NewRouter()
 	r.Handle("/", staticC.Home).Methods("GET")
 	r.Handle("/contact", staticC.Contact).Methods("GET")
 	r.Handle("/faq", staticC.Faq).Methods("GET")
-	// r.Handle("/gallery", galleriesC.Gallery).Methods("GET")
+
+	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).
+		Methods("GET").Name(controllers.IndexGallery)
+	r.Handle("/galleries/new", requireVerifiedMw.Apply(galleriesC.NewView)).Methods("GET")
+	r.HandleFunc("/galleries/new", requireVerifiedMw.ApplyFn(galleriesC.Create)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).
+		Methods("GET").Name(controllers.ShowGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).
+		Methods("GET").Name(controllers.EditGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}", galleriesC.ImageShow).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/{filename}", galleriesC.Image).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/{variant:[a-z]+}/{filename}", galleriesC.Image).Methods("GET")
+	r.HandleFunc("/img/{galleryID:[0-9]+}/{imageID:[0-9]+}", imagesC.Transform).Methods("GET")
 
 	r.HandleFunc("/signup", usersC.New).Methods("GET")
 	r.HandleFunc("/signup", usersC.Create).Methods("POST")
 
 	r.Handle("/login", usersC.LoginView).Methods("GET")
 	r.HandleFunc("/login", usersC.Login).Methods("POST")
+	r.HandleFunc("/login/2fa", usersC.TwoFactor).Methods("GET")
+	r.HandleFunc("/login/2fa", usersC.CompleteTwoFactor).Methods("POST")
+	r.Handle("/forgot", usersC.ForgotPwView).Methods("GET")
+	r.HandleFunc("/forgot", usersC.InitiateReset).Methods("POST")
+	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
+	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
+	r.HandleFunc("/verify", usersC.Verify).Methods("GET")
+	r.HandleFunc("/verify/resend", requireUserMw.ApplyFn(usersC.ResendVerify)).Methods("POST")
+	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
+
+	r.HandleFunc("/account", requireUserMw.ApplyFn(usersC.Account)).Methods("GET")
+	r.HandleFunc("/account/profile", requireUserMw.ApplyFn(usersC.UpdateProfile)).Methods("POST")
+	r.HandleFunc("/account/email", requireUserMw.ApplyFn(usersC.UpdateEmail)).Methods("POST")
+	r.HandleFunc("/account/password", requireUserMw.ApplyFn(usersC.UpdatePassword)).Methods("POST")
+	r.HandleFunc("/account/2fa/enroll", requireUserMw.ApplyFn(twoFactorC.Enroll)).Methods("POST")
+	r.HandleFunc("/account/2fa/confirm", requireUserMw.ApplyFn(twoFactorC.Confirm)).Methods("POST")
+	r.HandleFunc("/account/2fa/recovery-codes", requireUserMw.ApplyFn(twoFactorC.RecoveryCodes)).Methods("POST")
+	r.HandleFunc("/account/2fa/disable", requireUserMw.ApplyFn(twoFactorC.Disable)).Methods("POST")
+	r.HandleFunc("/account/sessions", requireUserMw.ApplyFn(sessionsC.Index)).Methods("GET")
+	r.HandleFunc("/account/sessions/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(sessionsC.Revoke)).Methods("POST")
+	r.HandleFunc("/account/sessions/revoke-others", requireUserMw.ApplyFn(sessionsC.RevokeOthers)).Methods("POST")
+
+	r.HandleFunc("/admin/lockouts", requireAdminMw.ApplyFn(adminC.Lockouts)).Methods("GET")
 
 	r.HandleFunc("/cookietest", usersC.CookieTest).Methods("GET")
 
 	r.NotFoundHandler = http.HandlerFunc(NotFound)
 
-	http.ListenAndServe(":3000", r)
+	// the User middleware wraps every request, so any handler can
+	// find the signed-in user (if any) in the request context; the
+	// CSRF middleware then refuses forged form submissions, and
+	// gives every page the token its forms must include
+	http.ListenAndServe(":3000", userMw.Apply(csrfMw.Apply(r)))
+}
+
+// imageStore returns the store for gallery images: an S3 bucket if
+// one is configured, or else a local directory
+func imageStore() storage.Store {
+	if bucket := os.Getenv(s3BucketEnv); bucket != "" {
+		return &storage.S3{
+			Endpoint:        os.Getenv(s3EndpointEnv),
+			Region:          os.Getenv(s3RegionEnv),
+			Bucket:          bucket,
+			AccessKeyID:     os.Getenv(s3AccessKeyEnv),
+			SecretAccessKey: os.Getenv(s3SecretKeyEnv),
+		}
+	}
+	if dir := os.Getenv(imagesDirEnv); dir != "" {
+		return storage.Dir(dir)
+	}
+	return storage.Dir(imagesDir)
+}
+
+// loadKeys returns the development keys, replacing either keyring
+// that is configured in the environment
+func loadKeys() (models.Keys, error) {
+	keys := models.DefaultKeys()
+	if s := os.Getenv(hmacKeysEnv); s != "" {
+		kr, err := hash.ParseKeyring(s)
+		if err != nil {
+			return keys, fmt.Errorf("%s: %v", hmacKeysEnv, err)
+		}
+		keys.HMAC = kr
+	}
+	if s := os.Getenv(pepperKeysEnv); s != "" {
+		kr, err := hash.ParseKeyring(s)
+		if err != nil {
+			return keys, fmt.Errorf("%s: %v", pepperKeysEnv, err)
+		}
+		keys.Pepper = kr
+	}
+	return keys, nil
 }
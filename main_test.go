@@ -7,19 +7,26 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/mux"
+
 	"github.com/peterpla/webdevgo/controllers"
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/email"
+	"github.com/peterpla/webdevgo/middleware"
 	"github.com/peterpla/webdevgo/models"
+	"github.com/peterpla/webdevgo/password"
+	"github.com/peterpla/webdevgo/storage"
 )
 
 func TestViewHandlers(t *testing.T) {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
 		"dbname=%s sslmode=disable",
 		dbHost, dbPort, dbUser, dbName)
-	us, err := models.NewUserService(psqlInfo)
+	services, err := models.NewServices(psqlInfo, models.DefaultKeys(), password.DefaultPolicy(), &storage.Memory{})
 	if err != nil {
 		panic(err)
 	}
-	defer us.Close()
+	defer services.User.Close()
 
 	type testset struct {
 		method   string
@@ -29,15 +36,18 @@ func TestViewHandlers(t *testing.T) {
 	}
 
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(us)
-	galleriesC := controllers.NewGalleries()
+	usersC := controllers.NewUsers(services.User, services.Session, services.TwoFactor, services.LoginThrottle, cookie.Policy{}, email.NewClient(&email.Memory{}, "test@whatever.com", "http://localhost:3000"))
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, mux.NewRouter())
+	requireUserMw := middleware.RequireUser{
+		User: middleware.User{UserService: services.User},
+	}
 
 	var tests = []testset{
 		{"GET", "/blah", NotFound, http.StatusNotFound},
 		{"GET", "/contact", staticC.Contact.ServeHTTP, http.StatusOK},
 		{"GET", "/faq", staticC.Faq.ServeHTTP, http.StatusOK},
 		{"GET", "/", staticC.Home.ServeHTTP, http.StatusOK},
-		{"GET", "/galleries/new", galleriesC.Gallery.ServeHTTP, http.StatusOK},
+		{"GET", "/galleries/new", requireUserMw.Apply(galleriesC.NewView), http.StatusFound}, // not signed in, redirect to /login
 		{"GET", "/signup", usersC.New, http.StatusOK},
 		// {"POST", "/signup", usersC.Create, http.StatusOK}, // need to populate form body
 	}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// Names under which the anti-forgery token is submitted. Forms
+// include CSRFField (see the csrfField template function); scripts
+// may send the CSRFHeader instead.
+const (
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRF middleware protects against cross-site request forgery. Each
+// browser session is given a random secret in a cookie, and the
+// token that forms must submit is the secret's HMAC, so another site
+// can neither read the token nor plant a matching pair. Requests
+// that change state (anything but GET, HEAD, OPTIONS and TRACE)
+// without a valid token are rejected.
+type CSRF struct {
+	HMAC    hash.HMAC
+	Cookies cookie.Policy
+
+	// Failure handles rejected requests. It should respond with
+	// 403 Forbidden; if nil, a plain 403 error is written.
+	Failure http.Handler
+
+	// MaxBodyBytes limits the size of the bodies of requests that
+	// need a token, since the form is read here to find it, and
+	// MaxMultipartBytes does the same for multipart/form-data
+	// requests, such as uploads. Larger requests are rejected with
+	// 413 Request Entity Too Large. Zero means no limit.
+	MaxBodyBytes      int64
+	MaxMultipartBytes int64
+}
+
+// Apply wraps an http.Handler with the CSRF middleware
+func (mw *CSRF) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn wraps an http.HandlerFunc with the CSRF middleware
+func (mw *CSRF) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a missing or unreadable cookie leaves secret empty
+		secret, _ := mw.Cookies.Get(r, cookie.CSRF)
+
+		if !csrfSafeMethod(r.Method) {
+			if limit := mw.bodyLimit(r); limit > 0 {
+				if r.ContentLength > limit {
+					http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			submitted := r.Header.Get(CSRFHeader)
+			if submitted == "" {
+				submitted = r.PostFormValue(CSRFField)
+			}
+			if secret == "" || !mw.HMAC.Verify(csrfInput(secret), submitted) {
+				mw.fail(w, r)
+				return
+			}
+		}
+
+		if secret == "" {
+			var err error
+			secret, err = rand.RememberToken()
+			if err != nil {
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+			if err := mw.Cookies.Set(w, r, cookie.CSRF, secret); err != nil {
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		token := mw.HMAC.Hash(csrfInput(secret))
+		next(w, r.WithContext(context.WithCSRFToken(r.Context(), token)))
+	})
+}
+
+// fail responds to a request without a valid token
+func (mw *CSRF) fail(w http.ResponseWriter, r *http.Request) {
+	if mw.Failure == nil {
+		http.Error(w, "Forbidden - invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	mw.Failure.ServeHTTP(w, r)
+}
+
+// bodyLimit returns the most bytes of r's body that may be read
+func (mw *CSRF) bodyLimit(r *http.Request) int64 {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return mw.MaxMultipartBytes
+	}
+	return mw.MaxBodyBytes
+}
+
+// csrfInput is what is hashed to make a token, so it can't be
+// mistaken for any other HMAC the app makes
+func csrfInput(secret string) string {
+	return "csrf:" + secret
+}
+
+// csrfSafeMethod reports whether requests using method must not
+// change state, and so don't need a token
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/hash"
+)
+
+func TestCSRF(t *testing.T) {
+	mw := CSRF{HMAC: hash.NewHMAC("secret-hmac-key")}
+
+	var gotToken string
+	var called bool
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		gotToken = context.CSRFToken(r.Context())
+	})
+
+	// a first visit sets the secret cookie and provides a token
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/signup", nil))
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != cookie.CSRF.Name || !cookies[0].HttpOnly {
+		t.Fatalf("GET: expected an HttpOnly %q cookie, got %v", cookie.CSRF.Name, cookies)
+	}
+	secret := cookies[0]
+	token := gotToken
+	if token == "" || token == secret.Value {
+		t.Fatalf("GET: expected a token distinct from the secret, got %q", token)
+	}
+
+	// later visits reuse the secret, and so the token
+	req := httptest.NewRequest("GET", "/login", nil)
+	req.AddCookie(secret)
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	if len(rr.Result().Cookies()) != 0 || gotToken != token {
+		t.Errorf("GET with cookie: expected no new cookie and the same token")
+	}
+
+	otherMw := CSRF{HMAC: hash.NewHMAC("some-other-key")}
+	otherToken := otherMw.HMAC.Hash(csrfInput(secret.Value))
+
+	type testset struct {
+		name    string
+		cookie  bool
+		field   string
+		header  string
+		expCode int
+	}
+
+	var tests = []testset{
+		{"no token", true, "", "", http.StatusForbidden},
+		{"no cookie", false, token, "", http.StatusForbidden},
+		{"wrong token", true, otherToken, "", http.StatusForbidden},
+		{"secret as token", true, secret.Value, "", http.StatusForbidden},
+		{"form field", true, token, "", http.StatusOK},
+		{"header", true, "", token, http.StatusOK},
+	}
+
+	for _, r := range tests {
+		called = false
+		form := url.Values{"email": {"bozo@clown.net"}}
+		if r.field != "" {
+			form.Set(CSRFField, r.field)
+		}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if r.header != "" {
+			req.Header.Set(CSRFHeader, r.header)
+		}
+		if r.cookie {
+			req.AddCookie(secret)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != r.expCode {
+			t.Errorf("%s: got status %d, want %d", r.name, rr.Code, r.expCode)
+		}
+		if called != (r.expCode == http.StatusOK) {
+			t.Errorf("%s: handler called = %v, want %v", r.name, called, !called)
+		}
+	}
+
+	// a Failure handler replaces the plain error
+	mw.Failure = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	rr = httptest.NewRecorder()
+	mw.ApplyFn(func(http.ResponseWriter, *http.Request) {})(rr, httptest.NewRequest("POST", "/login", nil))
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("Failure: got status %d, want %d", rr.Code, http.StatusTeapot)
+	}
+}
+
+func TestCSRFBodyLimits(t *testing.T) {
+	mw := CSRF{
+		HMAC:              hash.NewHMAC("secret-hmac-key"),
+		MaxBodyBytes:      100,
+		MaxMultipartBytes: 1000,
+	}
+	var called bool
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/", nil))
+	secret := rr.Result().Cookies()[0]
+	token := mw.HMAC.Hash(csrfInput(secret.Value))
+
+	multipartBody := func(padding int) (string, string) {
+		var buf strings.Builder
+		mpw := multipart.NewWriter(&buf)
+		mpw.WriteField("padding", strings.Repeat("x", padding))
+		mpw.WriteField(CSRFField, token)
+		mpw.Close()
+		return buf.String(), mpw.FormDataContentType()
+	}
+
+	type testset struct {
+		name        string
+		body        string
+		contentType string
+		chunked     bool
+		expCode     int
+	}
+
+	formBody := func(padding int) string {
+		form := url.Values{"padding": {strings.Repeat("x", padding)}}
+		return form.Encode() + "&" + CSRFField + "=" + url.QueryEscape(token)
+	}
+	smallMultipart, smallType := multipartBody(500)
+	largeMultipart, largeType := multipartBody(1000)
+	formType := "application/x-www-form-urlencoded"
+
+	var tests = []testset{
+		{"small form", formBody(0), formType, false, http.StatusOK},
+		{"large form", formBody(100), formType, false, http.StatusRequestEntityTooLarge},
+		// without a length, the form is cut short, and the token lost
+		{"large chunked form", formBody(100), formType, true, http.StatusForbidden},
+		{"small multipart", smallMultipart, smallType, false, http.StatusOK},
+		{"large multipart", largeMultipart, largeType, false, http.StatusRequestEntityTooLarge},
+		{"large chunked multipart", largeMultipart, largeType, true, http.StatusForbidden},
+	}
+
+	for _, r := range tests {
+		called = false
+		req := httptest.NewRequest("POST", "/galleries/1/images", strings.NewReader(r.body))
+		req.Header.Set("Content-Type", r.contentType)
+		if r.chunked {
+			req.ContentLength = -1
+		}
+		req.AddCookie(secret)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != r.expCode {
+			t.Errorf("%s: got status %d, want %d", r.name, rr.Code, r.expCode)
+		}
+		if called != (r.expCode == http.StatusOK) {
+			t.Errorf("%s: handler called = %v, want %v", r.name, called, !called)
+		}
+	}
+}
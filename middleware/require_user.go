@@ -0,0 +1,118 @@
+// Package middleware holds http.Handler wrappers shared by
+// all of our controllers
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/models"
+)
+
+// User middleware looks up the user identified by the remember_token
+// cookie and, if found, stores them in the request context. Requests
+// without a signed-in user are passed along unchanged.
+type User struct {
+	models.UserService
+	Cookies cookie.Policy
+}
+
+// Apply wraps an http.Handler with the User middleware
+func (mw *User) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn wraps an http.HandlerFunc with the User middleware
+func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, mw.withUser(r))
+	})
+}
+
+// withUser returns r with the signed-in user added to its context,
+// or r unchanged if there is no signed-in user
+func (mw *User) withUser(r *http.Request) *http.Request {
+	if context.User(r.Context()) != nil {
+		// already looked up further up the chain
+		return r
+	}
+
+	token, err := mw.Cookies.Get(r, cookie.Remember)
+	if err != nil {
+		return r
+	}
+	user, err := mw.ByRemember(token)
+	if err != nil {
+		return r
+	}
+	return r.WithContext(context.WithUser(r.Context(), user))
+}
+
+// RequireUser middleware redirects to the login page unless
+// there is a signed-in user
+type RequireUser struct {
+	User
+}
+
+// Apply wraps an http.Handler with the RequireUser middleware
+func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn wraps an http.HandlerFunc with the RequireUser middleware
+func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = mw.withUser(r)
+		if context.User(r.Context()) == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireVerified middleware requires a signed-in user who has
+// verified their email address. Users who have not are sent to
+// the email verification page.
+type RequireVerified struct {
+	RequireUser
+}
+
+// Apply wraps an http.Handler with the RequireVerified middleware
+func (mw *RequireVerified) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn wraps an http.HandlerFunc with the RequireVerified middleware
+func (mw *RequireVerified) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.RequireUser.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		if !context.User(r.Context()).EmailVerified() {
+			http.Redirect(w, r, "/verify", http.StatusFound)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireAdmin middleware requires a signed-in admin user. Anyone
+// else gets a 404, so the admin pages don't reveal they exist.
+type RequireAdmin struct {
+	RequireUser
+}
+
+// Apply wraps an http.Handler with the RequireAdmin middleware
+func (mw *RequireAdmin) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn wraps an http.HandlerFunc with the RequireAdmin middleware
+func (mw *RequireAdmin) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.RequireUser.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		if !context.User(r.Context()).Admin {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/models"
+)
+
+// fakeUserService resolves the single remember token "valid-token"
+type fakeUserService struct {
+	models.UserService
+}
+
+func (f *fakeUserService) ByRemember(token string) (*models.User, error) {
+	switch token {
+	case "valid-token":
+		return &models.User{Name: "Bozo Clown"}, nil
+	case "verified-token":
+		now := time.Now()
+		return &models.User{Name: "Bozo Clown", EmailVerifiedAt: &now}, nil
+	case "admin-token":
+		return &models.User{Name: "Bozo Clown", Admin: true}, nil
+	}
+	return nil, models.ErrNotFound
+}
+
+func TestRequireUser(t *testing.T) {
+	mw := RequireUser{User: User{UserService: &fakeUserService{}}}
+
+	var gotUser *models.User
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = context.User(r.Context())
+	})
+
+	type testset struct {
+		cookie      string
+		expCode     int
+		expSignedIn bool
+	}
+
+	var tests = []testset{
+		{"", http.StatusFound, false},
+		{"bogus-token", http.StatusFound, false},
+		{"valid-token", http.StatusOK, true},
+	}
+
+	for _, r := range tests {
+		gotUser = nil
+		req := httptest.NewRequest("GET", "/galleries", nil)
+		if r.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "remember_token", Value: r.cookie})
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != r.expCode {
+			t.Errorf("cookie %q: got status %d, want %d", r.cookie, rr.Code, r.expCode)
+		}
+		if r.expCode == http.StatusFound && rr.Header().Get("Location") != "/login" {
+			t.Errorf("cookie %q: got redirect %q, want %q", r.cookie, rr.Header().Get("Location"), "/login")
+		}
+		if (gotUser != nil) != r.expSignedIn {
+			t.Errorf("cookie %q: got user %+v, want signed in %t", r.cookie, gotUser, r.expSignedIn)
+		}
+	}
+}
+
+func TestUserIsOptional(t *testing.T) {
+	mw := User{UserService: &fakeUserService{}}
+
+	called := false
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if user := context.User(r.Context()); user != nil {
+			t.Errorf("expected no user in context, got %+v", user)
+		}
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/", nil))
+	if !called || rr.Code != http.StatusOK {
+		t.Errorf("expected handler to be called with status 200, got called=%t status=%d", called, rr.Code)
+	}
+}
+
+func TestRequireVerified(t *testing.T) {
+	mw := RequireVerified{
+		RequireUser: RequireUser{User: User{UserService: &fakeUserService{}}},
+	}
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {})
+
+	type testset struct {
+		cookie      string
+		expCode     int
+		expLocation string
+	}
+
+	var tests = []testset{
+		{"", http.StatusFound, "/login"},
+		{"valid-token", http.StatusFound, "/verify"},
+		{"verified-token", http.StatusOK, ""},
+	}
+
+	for _, r := range tests {
+		req := httptest.NewRequest("GET", "/galleries/new", nil)
+		if r.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "remember_token", Value: r.cookie})
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != r.expCode || rr.Header().Get("Location") != r.expLocation {
+			t.Errorf("cookie %q: got %d %q, want %d %q", r.cookie,
+				rr.Code, rr.Header().Get("Location"), r.expCode, r.expLocation)
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	mw := RequireAdmin{
+		RequireUser: RequireUser{User: User{UserService: &fakeUserService{}}},
+	}
+	handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {})
+
+	type testset struct {
+		cookie      string
+		expCode     int
+		expLocation string
+	}
+
+	var tests = []testset{
+		{"", http.StatusFound, "/login"},
+		{"verified-token", http.StatusNotFound, ""},
+		{"admin-token", http.StatusOK, ""},
+	}
+
+	for _, r := range tests {
+		req := httptest.NewRequest("GET", "/admin/lockouts", nil)
+		if r.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "remember_token", Value: r.cookie})
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != r.expCode || rr.Header().Get("Location") != r.expLocation {
+			t.Errorf("cookie %q: got %d %q, want %d %q", r.cookie,
+				rr.Code, rr.Header().Get("Location"), r.expCode, r.expLocation)
+		}
+	}
+}
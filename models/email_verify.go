@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/peterpla/webdevgo/hash"
+)
+
+// emailVerifyDuration is how long an email verification link remains valid
+const emailVerifyDuration = 48 * time.Hour
+
+// EmailVerified reports whether the user has confirmed they own
+// their email address
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// EmailVerifyToken returns a signed token to include in an email
+// verification link. The token names the user, the address being
+// verified and an expiry, so no database record is needed; changing
+// email address invalidates any outstanding links.
+func (us *userService) EmailVerifyToken(user *User) (string, error) {
+	if user.ID <= 0 {
+		return "", ErrIDInvalid
+	}
+	return us.tokens.Encode(hash.Claims{
+		Purpose: tokenPurposeVerify,
+		Subject: user.ID,
+		Data:    user.Email,
+		Expires: time.Now().Add(emailVerifyDuration),
+	})
+}
+
+// VerifyEmail checks the token's signature and expiry, and marks the
+// user's email address as verified. Any problem with the token
+// results in ErrTokenInvalid.
+func (us *userService) VerifyEmail(token string) (*User, error) {
+	claims, err := decodeToken(us.tokens, token, tokenPurposeVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := us.ByID(claims.Subject)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if user.Email != claims.Data {
+		// the link was sent to an address the user no longer uses
+		return nil, ErrTokenInvalid
+	}
+
+	if user.EmailVerified() {
+		return user, nil
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterpla/webdevgo/hash"
+)
+
+func TestVerifyEmailRejectsBadTokens(t *testing.T) {
+	tokens := hash.NewTokenCodec(hash.NewHMAC(hmacSecretKey))
+	us := &userService{tokens: tokens}
+
+	user := &User{Email: "bozo@clown.net"}
+	user.ID = 42
+	token, err := us.EmailVerifyToken(user)
+	if err != nil {
+		t.Fatalf("us.EmailVerifyToken(): expected nil, got \"%v\"", err)
+	}
+
+	// forge a token for a different user, reusing the signature
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"p":"verify","s":1,"d":"bozo@clown.net","e":9999999999}`))
+	sig := token[strings.Index(token, ".")+1:]
+
+	// signed correctly, but already expired
+	expired, _ := tokens.Encode(hash.Claims{
+		Purpose: tokenPurposeVerify,
+		Subject: 42,
+		Data:    user.Email,
+		Expires: time.Now().Add(-time.Minute),
+	})
+
+	// signed correctly, but for a different purpose
+	wrongPurpose, _ := tokens.Encode(hash.Claims{
+		Purpose: tokenPurposeTwoFactor,
+		Subject: 42,
+		Data:    user.Email,
+		Expires: time.Now().Add(time.Hour),
+	})
+
+	for _, bad := range []string{
+		"",
+		"no-dot-here",
+		token + "x",
+		forged + "." + sig,
+		expired,
+		wrongPurpose,
+	} {
+		if _, err := us.VerifyEmail(bad); err != ErrTokenInvalid {
+			t.Errorf("us.VerifyEmail(%q): expected \"%v\", got \"%v\"", bad, ErrTokenInvalid, err)
+		}
+	}
+
+	if _, err := us.EmailVerifyToken(&User{}); err != ErrIDInvalid {
+		t.Errorf("us.EmailVerifyToken(no ID): expected \"%v\", got \"%v\"", ErrIDInvalid, err)
+	}
+}
@@ -1,30 +1,269 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
 
-// Gallery holds per-gallery data
+	"github.com/jinzhu/gorm"
+)
+
+// Gallery holds per-gallery data. Images is not stored with the
+// gallery; it is filled in from the ImageService when needed.
 type Gallery struct {
 	gorm.Model
-	UserID uint `gorm:"not_null;index"`
-	Title string `gorm:"not_null"`
-
+	UserID uint    `gorm:"not null;index"`
+	Title  string  `gorm:"not null"`
+	Images []Image `gorm:"-"`
 }
 
-// GalleryService interface ... [TODO: add documentation]
+// galleryTitleMaxLen is the maximum number of characters (runes)
+// allowed in a gallery title
+const galleryTitleMaxLen = 100
+
+var (
+	// ErrUserIDRequired is returned when a gallery is created or
+	// updated without the ID of the user who owns it
+	ErrUserIDRequired modelError = "models: user ID is required"
+
+	// ErrTitleRequired is returned when a gallery title is empty
+	// (after whitespace trimmed)
+	ErrTitleRequired modelError = "models: title is required"
+
+	// ErrTitleTooLong is returned when a gallery title is longer
+	// than galleryTitleMaxLen characters
+	ErrTitleTooLong = modelError(fmt.Sprintf(
+		"models: title must be no more than %d characters long", galleryTitleMaxLen))
+)
+
+// GalleryService interface methods are used to work with the gallery model
 type GalleryService interface {
 	GalleryDB
 }
 
-// GalleryDB interface ... [TODO: add documentation]
+// a compile-time error below indicates the galleryGorm type no longer matches
+// the GalleryDB interface. They should match.
+var _ GalleryDB = &galleryGorm{}
+
+// a compile-time error below indicates the galleryValidator type no longer
+// matches the GalleryDB interface. They should match.
+var _ GalleryDB = &galleryValidator{}
+
+// GalleryDB is used to interact with the galleries database
+//
+// For single gallery queries:
+// If the gallery is found, return the gallery and nil
+// If the gallery is not found, return nil and ErrNotFound
+// If another error occurs, return the error we receive, which
+// may not be an error generated by the models package.
 type GalleryDB interface {
+	// Methods for querying galleries
+	ByID(id uint) (*Gallery, error)
+	ByUserID(userID uint) ([]Gallery, error)
+
+	// Methods for altering a single gallery
 	Create(gallery *Gallery) error
+	Update(gallery *Gallery) error
+	Delete(id uint) error
+
+	// Migration helpers
+	AutoMigrate() error
+	DestructiveReset() error
 }
 
+// galleryGorm represents our database interaction layer
+// and implements the GalleryDB interface fully
 type galleryGorm struct {
 	db *gorm.DB
 }
 
+type galleryService struct {
+	GalleryDB
+}
+
+// galleryValidator is our validation/normalization layer that
+// validates and normalizes gallery data before passing it along
+// our interface chain
+type galleryValidator struct {
+	GalleryDB
+}
+
+// NewGalleryService returns a GalleryService INTERFACE that other
+// packages will use to access the galleries database.
+func NewGalleryService(db *gorm.DB) GalleryService {
+	return &galleryService{
+		GalleryDB: &galleryValidator{
+			GalleryDB: &galleryGorm{db},
+		},
+	}
+}
+
+/* ********** ********** ********** */
+/*         galleryGorm methods      */
+
+// Create expects the gallery fields to be validated and normalized,
+// and will create the gallery database record, populating the
+// gorm.Model data including the ID, CreatedAt, and UpdatedAt fields.
 func (gg *galleryGorm) Create(gallery *Gallery) error {
-	// TODO: implement this
+	return gg.db.Create(gallery).Error
+}
+
+// ByID will look up a gallery with the provided ID.
+// If the gallery is found, return a nil error
+// If the gallery is not found, return ErrNotFound
+// If there is another error, return an error with
+// more information about what went wrong.
+func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.Where("id = ?", id)
+	err := first(db, &gallery)
+	if err != nil {
+		return nil, err
+	}
+	return &gallery, nil
+}
+
+// ByUserID returns all galleries owned by the user with the
+// provided ID, oldest first. A user with no galleries results
+// in an empty slice and a nil error.
+func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	db := gg.db.Where("user_id = ?", userID).Order("created_at")
+	if err := db.Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
+// Update expects the gallery fields to be validated and normalized,
+// and will update the gallery's DB record with the provided Gallery object
+func (gg *galleryGorm) Update(gallery *Gallery) error {
+	return gg.db.Save(gallery).Error
+}
+
+// Delete expects the gallery ID to be validated, and will
+// delete the gallery with the provided ID
+func (gg *galleryGorm) Delete(id uint) error {
+	gallery := Gallery{Model: gorm.Model{ID: id}}
+	return gg.db.Delete(&gallery).Error
+}
+
+// DestructiveReset drops the galleries table and rebuilds it
+func (gg *galleryGorm) DestructiveReset() error {
+	err := gg.db.DropTableIfExists(&Gallery{}).Error
+	if err != nil {
+		return err
+	}
+	return gg.AutoMigrate()
+}
+
+// AutoMigrate will attempt to automaticaly migrate
+// the galleries table
+func (gg *galleryGorm) AutoMigrate() error {
+	if err := gg.db.AutoMigrate(&Gallery{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+/* ********** ********** ********** */
+/*     galleryValidator methods     */
+
+// Create will validate and normalize the gallery, then pass it to the
+// database layer to create the gallery record in the database
+func (gv *galleryValidator) Create(gallery *Gallery) error {
+	err := runGalleryValFns(gallery,
+		gv.userIDRequired,
+		gv.normalizeTitle,
+		gv.titleRequired, // after normalizeTitle - sequence matters!
+		gv.titleMaxLength)
+	if err != nil {
+		return err
+	}
+	return gv.GalleryDB.Create(gallery)
+}
+
+// Update will validate and normalize the gallery, then pass it to the
+// database layer to update the gallery record in the database
+func (gv *galleryValidator) Update(gallery *Gallery) error {
+	err := runGalleryValFns(gallery,
+		gv.idGreaterThan(0),
+		gv.userIDRequired,
+		gv.normalizeTitle,
+		gv.titleRequired, // after normalizeTitle - sequence matters!
+		gv.titleMaxLength)
+	if err != nil {
+		return err
+	}
+	return gv.GalleryDB.Update(gallery)
+}
+
+// Delete will validate the provided gallery ID, then pass to the database
+// layer to delete the gallery record from the database.
+func (gv *galleryValidator) Delete(id uint) error {
+	var gallery Gallery
+	gallery.ID = id
+
+	err := runGalleryValFns(&gallery, gv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return gv.GalleryDB.Delete(id)
+}
+
+// ensure the gallery has an owner
+func (gv *galleryValidator) userIDRequired(gallery *Gallery) error {
+	if gallery.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+// normalize title by trimming whitespace
+func (gv *galleryValidator) normalizeTitle(gallery *Gallery) error {
+	gallery.Title = strings.TrimSpace(gallery.Title)
 	return nil
-}
\ No newline at end of file
+}
+
+// ensure title is present
+func (gv *galleryValidator) titleRequired(gallery *Gallery) error {
+	if gallery.Title == "" {
+		return ErrTitleRequired
+	}
+	return nil
+}
+
+// ensure title does not exceed the maximum length
+func (gv *galleryValidator) titleMaxLength(gallery *Gallery) error {
+	if utf8.RuneCountInString(gallery.Title) > galleryTitleMaxLen {
+		return ErrTitleTooLong
+	}
+	return nil
+}
+
+// idGreaterThan ensures the ID is greater than the provided argument
+func (gv *galleryValidator) idGreaterThan(n uint) galValFn {
+	return galValFn(func(gallery *Gallery) error {
+		if gallery.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
+/* ********** ********** ********** */
+/*     galleryValidator helpers     */
+
+// all gallery validation/normalization functions implement this signature
+// to simplify runGalleryValFns
+type galValFn func(*Gallery) error
+
+// iterate through the sequence of galValFn-conforming validation/normalization functions
+func runGalleryValFns(gallery *Gallery, fns ...galValFn) error {
+	for _, fn := range fns {
+		if err := fn(gallery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
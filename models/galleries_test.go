@@ -0,0 +1,100 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGalleryCreateByIDUpdateAndDelete(t *testing.T) {
+	if err := services.Gallery.AutoMigrate(); err != nil {
+		t.Fatalf("gs.AutoMigrate(): expected nil, got \"%v\"", err)
+	}
+
+	// random 16-bit integer to add to test gallery title and owner
+	rand.Seed(time.Now().UnixNano())
+	rnd := rand.Intn(math.MaxUint16)
+
+	gallery := Gallery{
+		UserID: uint(rnd) + 1,
+		Title:  fmt.Sprintf("Test%d Gallery", rnd),
+	}
+	if err := services.Gallery.Create(&gallery); err != nil {
+		t.Fatalf("gs.Create(): expected nil, got \"%v\"", err)
+	}
+
+	found, err := services.Gallery.ByID(gallery.ID)
+	if err != nil {
+		t.Fatalf("gs.ByID(): expected nil, got \"%v\"", err)
+	}
+	if found.Title != gallery.Title {
+		t.Errorf("gs.ByID(): expected title %q, got %q", gallery.Title, found.Title)
+	}
+
+	found.Title = found.Title + " Updated"
+	if err := services.Gallery.Update(found); err != nil {
+		t.Fatalf("gs.Update(): expected nil, got \"%v\"", err)
+	}
+
+	galleries, err := services.Gallery.ByUserID(gallery.UserID)
+	if err != nil {
+		t.Fatalf("gs.ByUserID(): expected nil, got \"%v\"", err)
+	}
+	if len(galleries) == 0 || galleries[len(galleries)-1].Title != found.Title {
+		t.Errorf("gs.ByUserID(): expected updated gallery %q in %+v", found.Title, galleries)
+	}
+
+	if err := services.Gallery.Delete(gallery.ID); err != nil {
+		t.Fatalf("gs.Delete(): expected nil, got \"%v\"", err)
+	}
+	if _, err := services.Gallery.ByID(gallery.ID); err != ErrNotFound {
+		t.Fatalf("gs.ByID(): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+}
+
+func TestGalleryValidator(t *testing.T) {
+	gv := &galleryValidator{}
+
+	type testset struct {
+		gallery  Gallery
+		expErr   error
+		expTitle string
+	}
+
+	var tests = []testset{
+		{Gallery{UserID: 1, Title: "Vacation"}, nil, "Vacation"},
+		{Gallery{UserID: 1, Title: "  Vacation \n"}, nil, "Vacation"},
+		{Gallery{UserID: 0, Title: "Vacation"}, ErrUserIDRequired, "Vacation"},
+		{Gallery{UserID: 1, Title: "   "}, ErrTitleRequired, ""},
+		{Gallery{UserID: 1, Title: strings.Repeat("x", galleryTitleMaxLen)}, nil, strings.Repeat("x", galleryTitleMaxLen)},
+		{Gallery{UserID: 1, Title: strings.Repeat("x", galleryTitleMaxLen+1)}, ErrTitleTooLong, strings.Repeat("x", galleryTitleMaxLen+1)},
+	}
+
+	for _, r := range tests {
+		err := runGalleryValFns(&r.gallery,
+			gv.userIDRequired,
+			gv.normalizeTitle,
+			gv.titleRequired,
+			gv.titleMaxLength)
+		if err != r.expErr {
+			t.Errorf("runGalleryValFns(%q): got %v, want %v", r.gallery.Title, err, r.expErr)
+		}
+		if err == nil && r.gallery.Title != r.expTitle {
+			t.Errorf("normalizeTitle: got %q, want %q", r.gallery.Title, r.expTitle)
+		}
+	}
+
+	if want := fmt.Sprintf("%d characters", galleryTitleMaxLen); !strings.Contains(ErrTitleTooLong.Public(), want) {
+		t.Errorf("ErrTitleTooLong.Public(): got %q, want it to mention %q", ErrTitleTooLong.Public(), want)
+	}
+
+	if err := gv.Update(&Gallery{UserID: 1, Title: "Vacation"}); err != ErrIDInvalid {
+		t.Errorf("gv.Update(no ID): got %v, want %v", err, ErrIDInvalid)
+	}
+	if err := gv.Delete(0); err != ErrIDInvalid {
+		t.Errorf("gv.Delete(0): got %v, want %v", err, ErrIDInvalid)
+	}
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/peterpla/webdevgo/exif"
+	"github.com/peterpla/webdevgo/imaging"
+)
+
+// imageMetaMaxLen is the maximum number of characters (runes) kept
+// from each text field of an image's metadata
+const imageMetaMaxLen = 255
+
+// orientedJPEGQuality is the quality a JPEG is re-encoded at when it
+// is turned the right way up. It is higher than variantJPEGQuality,
+// since the result replaces the original.
+const orientedJPEGQuality = 95
+
+// ImageMeta is the camera metadata read from an uploaded JPEG's EXIF
+// data. Images without EXIF data have none. Fields the camera didn't
+// record are left at their zero values, or nil.
+type ImageMeta struct {
+	gorm.Model
+	ImageID      uint `gorm:"not null;unique_index"`
+	CameraMake   string
+	CameraModel  string
+	LensMake     string
+	LensModel    string
+	ExposureTime string // in seconds, e.g. "1/250"
+	FNumber      float64
+	FocalLength  float64 // in millimeters
+	ISO          int
+
+	// TakenAt is the camera's clock when the photo was taken, which
+	// EXIF doesn't tie to a time zone; it is kept as if UTC
+	TakenAt *time.Time
+
+	// Latitude and Longitude are in degrees, negative to the south
+	// and west. Altitude is in meters above sea level.
+	Latitude  *float64
+	Longitude *float64
+	Altitude  *float64
+}
+
+// Camera returns the camera's make and model, without the make
+// repeated when the model includes it, as many cameras record
+func (m *ImageMeta) Camera() string {
+	if strings.HasPrefix(strings.ToLower(m.CameraModel), strings.ToLower(m.CameraMake)) {
+		return m.CameraModel
+	}
+	return strings.TrimSpace(m.CameraMake + " " + m.CameraModel)
+}
+
+// Lens returns the lens's make and model, like Camera
+func (m *ImageMeta) Lens() string {
+	if strings.HasPrefix(strings.ToLower(m.LensModel), strings.ToLower(m.LensMake)) {
+		return m.LensModel
+	}
+	return strings.TrimSpace(m.LensMake + " " + m.LensModel)
+}
+
+// Exposure summarizes the exposure settings, e.g.
+// "1/250 s at f/2.8, ISO 400, 50 mm", leaving out any not recorded
+func (m *ImageMeta) Exposure() string {
+	var parts []string
+	switch {
+	case m.ExposureTime != "" && m.FNumber > 0:
+		parts = append(parts, fmt.Sprintf("%s s at f/%g", m.ExposureTime, m.FNumber))
+	case m.ExposureTime != "":
+		parts = append(parts, m.ExposureTime+" s")
+	case m.FNumber > 0:
+		parts = append(parts, fmt.Sprintf("f/%g", m.FNumber))
+	}
+	if m.ISO > 0 {
+		parts = append(parts, fmt.Sprintf("ISO %d", m.ISO))
+	}
+	if m.FocalLength > 0 {
+		parts = append(parts, fmt.Sprintf("%g mm", m.FocalLength))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// HasLocation reports whether the photo's GPS position was recorded
+func (m *ImageMeta) HasLocation() bool {
+	return m.Latitude != nil && m.Longitude != nil
+}
+
+// Location returns the photo's GPS position, e.g.
+// "37.774833, -122.419167", or "" if none was recorded
+func (m *ImageMeta) Location() string {
+	if !m.HasLocation() {
+		return ""
+	}
+	return fmt.Sprintf("%.6f, %.6f", *m.Latitude, *m.Longitude)
+}
+
+// Elevation returns the photo's GPS altitude, e.g. "152 m above sea
+// level", or "" if none was recorded
+func (m *ImageMeta) Elevation() string {
+	if m.Altitude == nil {
+		return ""
+	}
+	if *m.Altitude < 0 {
+		return fmt.Sprintf("%.0f m below sea level", -*m.Altitude)
+	}
+	return fmt.Sprintf("%.0f m above sea level", *m.Altitude)
+}
+
+// MapURL returns the URL of a map centered on the photo's GPS
+// position, or "" if none was recorded
+func (m *ImageMeta) MapURL() string {
+	if !m.HasLocation() {
+		return ""
+	}
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.6f&mlon=%.6f#map=15/%.6f/%.6f",
+		*m.Latitude, *m.Longitude, *m.Latitude, *m.Longitude)
+}
+
+// readImageMeta reads the metadata from a JPEG's EXIF data, returning
+// it along with the image's EXIF orientation. The metadata is nil if
+// the file has no usable EXIF data.
+func readImageMeta(data []byte) (*ImageMeta, int) {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0
+	}
+
+	meta := &ImageMeta{
+		CameraMake:  x.Make,
+		CameraModel: x.Model,
+		LensMake:    x.LensMake,
+		LensModel:   x.LensModel,
+		FNumber:     x.FNumber.Float(),
+		FocalLength: x.FocalLength.Float(),
+		ISO:         x.ISO,
+	}
+	if x.ExposureTime.Valid() && x.ExposureTime.Num > 0 {
+		meta.ExposureTime = exposureTime(x.ExposureTime)
+	}
+	if !x.Taken.IsZero() {
+		taken := x.Taken
+		meta.TakenAt = &taken
+	}
+	if x.GPS != nil {
+		lat, lon := x.GPS.Latitude, x.GPS.Longitude
+		meta.Latitude, meta.Longitude = &lat, &lon
+		if x.GPS.HasAltitude {
+			alt := x.GPS.Altitude
+			meta.Altitude = &alt
+		}
+	}
+	return meta, x.Orientation
+}
+
+// exposureTime formats an exposure time in seconds the way cameras
+// display it: as a fraction of a second below half a second, e.g.
+// "1/250", and as a decimal otherwise, e.g. "2.5"
+func exposureTime(r exif.Rational) string {
+	seconds := r.Float()
+	if seconds < 0.5 {
+		return fmt.Sprintf("1/%g", math.Round(1/seconds))
+	}
+	return fmt.Sprintf("%g", math.Round(seconds*10)/10)
+}
+
+// orientJPEG returns a JPEG turned the right way up for its EXIF
+// orientation. The result has no EXIF data, so browsers won't turn
+// it again.
+func orientJPEG(data []byte, orientation int) ([]byte, error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageUnreadable
+	}
+	if cfg.Width*cfg.Height > imageMaxPixels {
+		return nil, ErrImageTooManyPixels
+	}
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageUnreadable
+	}
+
+	var buf bytes.Buffer
+	dst := imaging.Orient(src, orientation)
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: orientedJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/* ********** ********** ********** */
+/*    imageGorm metadata methods    */
+
+// MetaByImageID will look up the metadata of the image with the
+// provided ID
+func (ig *imageGorm) MetaByImageID(imageID uint) (*ImageMeta, error) {
+	var meta ImageMeta
+	db := ig.db.Where("image_id = ?", imageID)
+	if err := first(db, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// CreateMeta expects the metadata to be validated and normalized,
+// and will create its database record
+func (ig *imageGorm) CreateMeta(meta *ImageMeta) error {
+	return ig.db.Create(meta).Error
+}
+
+/* ********** ********** ********** */
+/* imageValidator metadata methods  */
+
+// CreateMeta will validate and normalize the metadata, then pass it
+// to the database layer to create its record in the database
+func (iv *imageValidator) CreateMeta(meta *ImageMeta) error {
+	if meta.ImageID <= 0 {
+		return ErrIDInvalid
+	}
+	for _, s := range []*string{&meta.CameraMake, &meta.CameraModel,
+		&meta.LensMake, &meta.LensModel, &meta.ExposureTime} {
+		*s = normalizeMetaText(*s)
+	}
+	return iv.ImageDB.CreateMeta(meta)
+}
+
+// normalizeMetaText drops the invalid UTF-8 and control characters
+// cameras sometimes leave in EXIF text, trims whitespace, and
+// truncates it to imageMetaMaxLen
+func normalizeMetaText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) > imageMetaMaxLen {
+		s = string([]rune(s)[:imageMetaMaxLen])
+	}
+	return s
+}
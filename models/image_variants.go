@@ -0,0 +1,170 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	// register the decoders for the accepted upload types
+	_ "image/gif"
+
+	"github.com/peterpla/webdevgo/imaging"
+)
+
+// imageMaxPixels is the most pixels an uploaded image may have, so a
+// small file can't decode to an enormous image
+const imageMaxPixels = 50000000
+
+// variantJPEGQuality is the quality JPEG variants are encoded at
+const variantJPEGQuality = 85
+
+var (
+	// ErrImageUnreadable is returned when an uploaded file looks like
+	// an image, but can't be decoded
+	ErrImageUnreadable modelError = "models: image could not be read, it may be damaged"
+
+	// ErrImageTooManyPixels is returned when an uploaded image has
+	// more than imageMaxPixels pixels
+	ErrImageTooManyPixels = modelError(fmt.Sprintf(
+		"models: image must be no more than %d megapixels", imageMaxPixels/1000000))
+)
+
+// ImageVariant is a resized copy made of every uploaded image, scaled
+// down to fit within MaxWidth x MaxHeight. Images that already fit
+// get no copy; the original is used instead.
+type ImageVariant struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+}
+
+// DefaultImageVariants returns the variants used for gallery grids
+// (thumb), single images (medium), and high density displays (large)
+func DefaultImageVariants() []ImageVariant {
+	return []ImageVariant{
+		{Name: "thumb", MaxWidth: 320, MaxHeight: 320},
+		{Name: "medium", MaxWidth: 800, MaxHeight: 800},
+		{Name: "large", MaxWidth: 1600, MaxHeight: 1600},
+	}
+}
+
+// variantSize is a variant stored for an image
+type variantSize struct {
+	name          string
+	width, height int
+}
+
+// variantSizes parses the image's VariantSizes, e.g.
+// "thumb:320x240 medium:800x600"
+func (i *Image) variantSizes() []variantSize {
+	var sizes []variantSize
+	for _, field := range strings.Fields(i.VariantSizes) {
+		var vs variantSize
+		j := strings.Index(field, ":")
+		if j < 0 {
+			continue
+		}
+		vs.name = field[:j]
+		if _, err := fmt.Sscanf(field[j+1:], "%dx%d", &vs.width, &vs.height); err != nil {
+			continue
+		}
+		sizes = append(sizes, vs)
+	}
+	return sizes
+}
+
+// HasVariant reports whether the named variant was stored for the image
+func (i *Image) HasVariant(name string) bool {
+	for _, vs := range i.variantSizes() {
+		if vs.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// VariantPath returns the URL path the named variant is served from,
+// or the original's if the image has no such variant
+func (i *Image) VariantPath(name string) string {
+	if !i.HasVariant(name) {
+		return i.Path()
+	}
+	return fmt.Sprintf("/galleries/%d/images/%d/%s/%s",
+		i.GalleryID, i.ID, name, url.PathEscape(i.Filename))
+}
+
+// Srcset returns a srcset attribute value listing each of the image's
+// variants and the original by width, so browsers can choose the
+// smallest that will look sharp
+func (i *Image) Srcset() string {
+	var entries []string
+	for _, vs := range i.variantSizes() {
+		entries = append(entries, i.VariantPath(vs.name)+" "+strconv.Itoa(vs.width)+"w")
+	}
+	if i.Width > 0 {
+		entries = append(entries, i.Path()+" "+strconv.Itoa(i.Width)+"w")
+	}
+	return strings.Join(entries, ", ")
+}
+
+// VariantContentType returns the content type of the named variant's
+// file: JPEG for JPEG images, and PNG for the rest
+func (i *Image) VariantContentType(name string) string {
+	if name == "" || i.ContentType == "image/jpeg" {
+		return i.ContentType
+	}
+	return "image/png"
+}
+
+// variantKey returns the storage key the named variant is kept under
+func (i *Image) variantKey(name string) string {
+	return galleryKeyPrefix(i.GalleryID) + fmt.Sprintf("%d_%s%s",
+		i.ID, name, imageExts[i.VariantContentType(name)])
+}
+
+// makeVariants decodes the image's file, sets its Width, Height and
+// VariantSizes, and returns each variant it needs, encoded, by name
+func makeVariants(img *Image, data []byte, variants []ImageVariant) (map[string][]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageUnreadable
+	}
+	if cfg.Width*cfg.Height > imageMaxPixels {
+		return nil, ErrImageTooManyPixels
+	}
+	// GIFs decode to their first frame
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrImageUnreadable
+	}
+	img.Width, img.Height = cfg.Width, cfg.Height
+
+	encoded := make(map[string][]byte)
+	var sizes []string
+	for _, v := range variants {
+		w, h := imaging.FitSize(img.Width, img.Height, v.MaxWidth, v.MaxHeight)
+		if w == img.Width && h == img.Height {
+			continue
+		}
+
+		var buf bytes.Buffer
+		resized := imaging.Resize(src, w, h)
+		if img.VariantContentType(v.Name) == "image/jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: variantJPEGQuality})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, err
+		}
+		encoded[v.Name] = buf.Bytes()
+		sizes = append(sizes, fmt.Sprintf("%s:%dx%d", v.Name, w, h))
+	}
+	img.VariantSizes = strings.Join(sizes, " ")
+	return encoded, nil
+}
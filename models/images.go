@@ -0,0 +1,519 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/peterpla/webdevgo/storage"
+)
+
+// imageMaxBytes is the largest image file that can be uploaded
+const imageMaxBytes = 20 << 20
+
+// ImageMaxUploads is the most images that can be uploaded at once
+const ImageMaxUploads = 10
+
+// ImageUploadMaxBytes limits the size of a request uploading images:
+// room for ImageMaxUploads of the largest images accepted, plus the
+// rest of the form
+const ImageUploadMaxBytes = ImageMaxUploads*imageMaxBytes + 1<<20
+
+// imageFilenameMaxLen is the maximum number of characters (runes)
+// kept from an uploaded image's filename
+const imageFilenameMaxLen = 255
+
+// imageExts maps the content types accepted for upload to the
+// extension the stored file is given
+var imageExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+var (
+	// ErrGalleryIDRequired is returned when an image is created
+	// without the ID of the gallery it belongs to
+	ErrGalleryIDRequired modelError = "models: gallery ID is required"
+
+	// ErrFilenameRequired is returned when an image is uploaded
+	// without a filename
+	ErrFilenameRequired modelError = "models: filename is required"
+
+	// ErrImageRequired is returned when an upload contains no images
+	ErrImageRequired modelError = "models: please choose at least one image to upload"
+
+	// ErrTooManyImages is returned when an upload contains more than
+	// ImageMaxUploads images
+	ErrTooManyImages = modelError(fmt.Sprintf(
+		"models: please upload no more than %d images at once", ImageMaxUploads))
+
+	// ErrImageTypeInvalid is returned when an uploaded file is not
+	// a JPEG, PNG or GIF image
+	ErrImageTypeInvalid modelError = "models: image must be a JPEG, PNG or GIF"
+
+	// ErrImageTooLarge is returned when an uploaded file is larger
+	// than imageMaxBytes
+	ErrImageTooLarge = modelError(fmt.Sprintf(
+		"models: image must be no more than %d MB", imageMaxBytes>>20))
+)
+
+// Image is a photo in a gallery. Images are listed in Position order,
+// which is the order they were uploaded in. The file is kept in the
+// ImageService's storage.Store, along with its resized variants;
+// Filename is the name it was uploaded with, kept for display and
+// downloads. VariantSizes records which variants were made, and
+// their sizes (see ImageVariant). JPEGs with EXIF data also have an
+// ImageMeta record.
+type Image struct {
+	gorm.Model
+	GalleryID    uint   `gorm:"not null;index"`
+	Position     int    `gorm:"not null"`
+	Filename     string `gorm:"not null"`
+	ContentType  string `gorm:"not null"`
+	Size         int64
+	Width        int
+	Height       int
+	VariantSizes string
+}
+
+// Path returns the URL path the image is served from
+func (i *Image) Path() string {
+	return fmt.Sprintf("/galleries/%d/images/%d/%s",
+		i.GalleryID, i.ID, url.PathEscape(i.Filename))
+}
+
+// PagePath returns the URL path of the image's detail page
+func (i *Image) PagePath() string {
+	return fmt.Sprintf("/galleries/%d/images/%d", i.GalleryID, i.ID)
+}
+
+// key returns the storage key the image's file is kept under. Files
+// are named by image ID, so uploads with the same filename don't
+// collide.
+func (i *Image) key() string {
+	return galleryKeyPrefix(i.GalleryID) + fmt.Sprint(i.ID) + imageExts[i.ContentType]
+}
+
+// galleryKeyPrefix returns the storage key prefix shared by all of
+// a gallery's files
+func galleryKeyPrefix(galleryID uint) string {
+	return fmt.Sprintf("galleries/%d/", galleryID)
+}
+
+// ImageService interface methods are used to work with gallery images,
+// keeping each image's database record and file together
+type ImageService interface {
+	ByID(id uint) (*Image, error)
+
+	// ByGalleryID returns the gallery's images in Position order
+	ByGalleryID(galleryID uint) ([]Image, error)
+
+	// Create reads the image's file from r, sets its ContentType,
+	// Size and dimensions, and stores it and its variants as the last
+	// image in its gallery. JPEGs are turned the right way up for
+	// their EXIF orientation, and their EXIF metadata is kept.
+	Create(image *Image, r io.Reader) error
+
+	// Meta returns the image's EXIF metadata, or ErrNotFound if it
+	// has none
+	Meta(image *Image) (*ImageMeta, error)
+
+	// Open returns the file of the named variant of the image, or of
+	// the original if variant is "". The caller must close it.
+	Open(image *Image, variant string) (io.ReadCloser, error)
+
+	// Delete removes the image's records and files
+	Delete(image *Image) error
+
+	// DeleteByGalleryID removes all of a gallery's images
+	DeleteByGalleryID(galleryID uint) error
+
+	// Migration helpers
+	AutoMigrate() error
+	DestructiveReset() error
+}
+
+// a compile-time error below indicates the imageGorm type no longer matches
+// the ImageDB interface. They should match.
+var _ ImageDB = &imageGorm{}
+
+// a compile-time error below indicates the imageValidator type no longer
+// matches the ImageDB interface. They should match.
+var _ ImageDB = &imageValidator{}
+
+// ImageDB is used to interact with the images database
+//
+// For single image queries:
+// If the image is found, return the image and nil
+// If the image is not found, return nil and ErrNotFound
+// If another error occurs, return the error we receive, which
+// may not be an error generated by the models package.
+type ImageDB interface {
+	// Methods for querying images
+	ByID(id uint) (*Image, error)
+	ByGalleryID(galleryID uint) ([]Image, error)
+	MetaByImageID(imageID uint) (*ImageMeta, error)
+
+	// Methods for altering images
+	Create(image *Image) error
+	CreateMeta(meta *ImageMeta) error
+	Delete(id uint) error
+	DeleteByGalleryID(galleryID uint) error
+
+	// Migration helpers
+	AutoMigrate() error
+	DestructiveReset() error
+}
+
+// imageGorm represents our database interaction layer
+// and implements the ImageDB interface fully
+type imageGorm struct {
+	db *gorm.DB
+}
+
+// imageValidator is our validation/normalization layer that
+// validates and normalizes image data before passing it along
+// our interface chain
+type imageValidator struct {
+	ImageDB
+}
+
+// imageService keeps image files in a storage.Store, and their
+// records in the database
+type imageService struct {
+	db       ImageDB
+	store    storage.Store
+	variants []ImageVariant
+}
+
+// NewImageService returns an ImageService INTERFACE that other
+// packages will use to work with gallery images. Image files are
+// kept in store, along with the given variants of each.
+func NewImageService(db *gorm.DB, store storage.Store, variants []ImageVariant) ImageService {
+	return &imageService{
+		db: &imageValidator{
+			ImageDB: &imageGorm{db},
+		},
+		store:    store,
+		variants: variants,
+	}
+}
+
+/* ********** ********** ********** */
+/*       imageService methods       */
+
+// ByID looks up an image's record
+func (is *imageService) ByID(id uint) (*Image, error) {
+	return is.db.ByID(id)
+}
+
+// ByGalleryID looks up a gallery's image records
+func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
+	return is.db.ByGalleryID(galleryID)
+}
+
+// Create reads the file into memory, sniffs its content type, reads
+// a JPEG's EXIF metadata and turns it the right way up, makes its
+// variants, creates the image and metadata records, then stores the
+// files. If storing the metadata or a file fails, the records and any
+// files already stored are deleted again.
+func (is *imageService) Create(image *Image, r io.Reader) error {
+	// read one byte past the limit to tell a file of exactly
+	// imageMaxBytes from a larger one
+	data, err := ioutil.ReadAll(io.LimitReader(r, imageMaxBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > imageMaxBytes {
+		return ErrImageTooLarge
+	}
+	image.ContentType = http.DetectContentType(data)
+	if _, ok := imageExts[image.ContentType]; !ok {
+		return ErrImageTypeInvalid
+	}
+
+	var meta *ImageMeta
+	if image.ContentType == "image/jpeg" {
+		var orientation int
+		meta, orientation = readImageMeta(data)
+		if orientation > 1 {
+			if data, err = orientJPEG(data, orientation); err != nil {
+				return err
+			}
+		}
+	}
+	image.Size = int64(len(data))
+
+	variants, err := makeVariants(image, data, is.variants)
+	if err != nil {
+		return err
+	}
+	if err := is.db.Create(image); err != nil {
+		return err
+	}
+
+	if meta != nil {
+		meta.ImageID = image.ID
+		err = is.db.CreateMeta(meta)
+	}
+	if err == nil {
+		err = is.store.Put(image.key(), bytes.NewReader(data))
+	}
+	for name, b := range variants {
+		if err != nil {
+			break
+		}
+		err = is.store.Put(image.variantKey(name), bytes.NewReader(b))
+	}
+	if err != nil {
+		is.Delete(image)
+		return err
+	}
+	return nil
+}
+
+// Meta looks up the image's metadata record
+func (is *imageService) Meta(image *Image) (*ImageMeta, error) {
+	return is.db.MetaByImageID(image.ID)
+}
+
+// Open gets the file from the store
+func (is *imageService) Open(image *Image, variant string) (io.ReadCloser, error) {
+	if variant == "" {
+		return is.store.Get(image.key())
+	}
+	if !image.HasVariant(variant) {
+		return nil, storage.ErrNotFound
+	}
+	return is.store.Get(image.variantKey(variant))
+}
+
+// Delete deletes the image's records, then its files
+func (is *imageService) Delete(image *Image) error {
+	if err := is.db.Delete(image.ID); err != nil {
+		return err
+	}
+	for _, vs := range image.variantSizes() {
+		if err := is.store.Delete(image.variantKey(vs.name)); err != nil {
+			return err
+		}
+	}
+	return is.store.Delete(image.key())
+}
+
+// DeleteByGalleryID deletes the gallery's image and metadata records,
+// then every file stored for the gallery
+func (is *imageService) DeleteByGalleryID(galleryID uint) error {
+	if err := is.db.DeleteByGalleryID(galleryID); err != nil {
+		return err
+	}
+	infos, err := is.store.List(galleryKeyPrefix(galleryID))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := is.store.Delete(info.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AutoMigrate migrates the images and metadata tables
+func (is *imageService) AutoMigrate() error {
+	return is.db.AutoMigrate()
+}
+
+// DestructiveReset drops and rebuilds the images and metadata tables.
+// Image files are left in the store.
+func (is *imageService) DestructiveReset() error {
+	return is.db.DestructiveReset()
+}
+
+/* ********** ********** ********** */
+/*          imageGorm methods       */
+
+// ByID will look up an image with the provided ID
+func (ig *imageGorm) ByID(id uint) (*Image, error) {
+	var image Image
+	db := ig.db.Where("id = ?", id)
+	if err := first(db, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// ByGalleryID returns all images in the gallery with the provided
+// ID, in Position order. A gallery with no images results in an
+// empty slice and a nil error.
+func (ig *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	db := ig.db.Where("gallery_id = ?", galleryID).Order("position, id")
+	if err := db.Find(&images).Error; err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Create expects the image fields to be validated and normalized,
+// and will create the image database record after the gallery's
+// existing images
+func (ig *imageGorm) Create(image *Image) error {
+	var last struct{ Position int }
+	err := ig.db.Model(&Image{}).
+		Where("gallery_id = ?", image.GalleryID).
+		Select("COALESCE(MAX(position), 0) AS position").
+		Scan(&last).Error
+	if err != nil {
+		return err
+	}
+	image.Position = last.Position + 1
+	return ig.db.Create(image).Error
+}
+
+// Delete expects the image ID to be validated, and will delete the
+// image record with the provided ID, and its metadata record. Records
+// are deleted outright, since their files are removed too.
+func (ig *imageGorm) Delete(id uint) error {
+	err := ig.db.Unscoped().Where("image_id = ?", id).Delete(&ImageMeta{}).Error
+	if err != nil {
+		return err
+	}
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Unscoped().Delete(&image).Error
+}
+
+// DeleteByGalleryID deletes every image record in the gallery, and
+// their metadata records
+func (ig *imageGorm) DeleteByGalleryID(galleryID uint) error {
+	images := ig.db.Model(&Image{}).Select("id").
+		Where("gallery_id = ?", galleryID).QueryExpr()
+	err := ig.db.Unscoped().Where("image_id IN (?)", images).Delete(&ImageMeta{}).Error
+	if err != nil {
+		return err
+	}
+	return ig.db.Unscoped().Where("gallery_id = ?", galleryID).Delete(&Image{}).Error
+}
+
+// DestructiveReset drops the images and metadata tables and
+// rebuilds them
+func (ig *imageGorm) DestructiveReset() error {
+	err := ig.db.DropTableIfExists(&ImageMeta{}, &Image{}).Error
+	if err != nil {
+		return err
+	}
+	return ig.AutoMigrate()
+}
+
+// AutoMigrate will attempt to automaticaly migrate
+// the images and metadata tables
+func (ig *imageGorm) AutoMigrate() error {
+	if err := ig.db.AutoMigrate(&Image{}, &ImageMeta{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+/* ********** ********** ********** */
+/*      imageValidator methods      */
+
+// Create will validate and normalize the image, then pass it to the
+// database layer to create the image record in the database
+func (iv *imageValidator) Create(image *Image) error {
+	err := runImageValFns(image,
+		iv.galleryIDRequired,
+		iv.normalizeFilename,
+		iv.filenameRequired, // after normalizeFilename - sequence matters!
+		iv.contentTypeAllowed)
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Create(image)
+}
+
+// Delete will validate the provided image ID, then pass to the database
+// layer to delete the image record from the database.
+func (iv *imageValidator) Delete(id uint) error {
+	var image Image
+	image.ID = id
+
+	err := runImageValFns(&image, iv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Delete(id)
+}
+
+// ensure the image belongs to a gallery
+func (iv *imageValidator) galleryIDRequired(image *Image) error {
+	if image.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+	return nil
+}
+
+// normalize filename by dropping any directories the browser sent,
+// trimming whitespace, and truncating it to imageFilenameMaxLen
+func (iv *imageValidator) normalizeFilename(image *Image) error {
+	name := strings.Replace(image.Filename, "\\", "/", -1)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > imageFilenameMaxLen {
+		name = string([]rune(name)[:imageFilenameMaxLen])
+	}
+	image.Filename = name
+	return nil
+}
+
+// ensure filename is present
+func (iv *imageValidator) filenameRequired(image *Image) error {
+	if image.Filename == "" || image.Filename == "." || image.Filename == ".." {
+		return ErrFilenameRequired
+	}
+	return nil
+}
+
+// ensure the image is of a type that can be uploaded
+func (iv *imageValidator) contentTypeAllowed(image *Image) error {
+	if _, ok := imageExts[image.ContentType]; !ok {
+		return ErrImageTypeInvalid
+	}
+	return nil
+}
+
+// idGreaterThan ensures the ID is greater than the provided argument
+func (iv *imageValidator) idGreaterThan(n uint) imgValFn {
+	return imgValFn(func(image *Image) error {
+		if image.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
+/* ********** ********** ********** */
+/*      imageValidator helpers      */
+
+// all image validation/normalization functions implement this signature
+// to simplify runImageValFns
+type imgValFn func(*Image) error
+
+// iterate through the sequence of imgValFn-conforming validation/normalization functions
+func runImageValFns(image *Image, fns ...imgValFn) error {
+	for _, fn := range fns {
+		if err := fn(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterpla/webdevgo/storage"
+)
+
+// pngHeader is enough of a PNG file for its content type to be sniffed
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// testImage returns a width x height image encoded as a PNG or JPEG
+func testImage(t *testing.T, width, height int, format string) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatalf("Encode(): expected nil, got \"%v\"", err)
+	}
+	return buf.String()
+}
+
+// exifJPEG returns a width x height JPEG whose EXIF data records the
+// given orientation, along with a camera, exposure settings, capture
+// time and GPS position
+func exifJPEG(t *testing.T, width, height, orientation int) string {
+	t.Helper()
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    []byte // big endian
+	}
+	u16 := func(v uint16) []byte { return []byte{byte(v >> 8), byte(v)} }
+	u32s := func(vs ...uint32) []byte {
+		b := make([]byte, 4*len(vs))
+		for i, v := range vs {
+			binary.BigEndian.PutUint32(b[4*i:], v)
+		}
+		return b
+	}
+	// ifd lays out an IFD at offset, with the values that don't fit
+	// in their entries after it
+	ifd := func(offset uint32, entries []entry) []byte {
+		var buf, values bytes.Buffer
+		valuesAt := offset + 2 + uint32(len(entries))*12 + 4
+		buf.Write(u16(uint16(len(entries))))
+		for _, e := range entries {
+			buf.Write(u16(e.tag))
+			buf.Write(u16(e.typ))
+			buf.Write(u32s(e.count))
+			if len(e.value) <= 4 {
+				buf.Write(append(e.value, make([]byte, 4-len(e.value))...))
+			} else {
+				buf.Write(u32s(valuesAt + uint32(values.Len())))
+				values.Write(e.value)
+			}
+		}
+		buf.Write(u32s(0))
+		buf.Write(values.Bytes())
+		return buf.Bytes()
+	}
+
+	exifIFD := []entry{
+		{0x829A, 5, 1, u32s(1, 250)},                       // ExposureTime
+		{0x829D, 5, 1, u32s(28, 10)},                       // FNumber
+		{0x8827, 3, 1, u16(400)},                           // ISO
+		{0x9003, 2, 20, []byte("2019:07:14 16:32:05\x00")}, // DateTimeOriginal
+		{0x920A, 5, 1, u32s(50, 1)},                        // FocalLength
+	}
+	gpsIFD := []entry{
+		{1, 2, 2, []byte("S\x00")},                // GPSLatitudeRef
+		{2, 5, 3, u32s(33, 1, 51, 1, 3540, 100)},  // GPSLatitude
+		{3, 2, 2, []byte("E\x00")},                // GPSLongitudeRef
+		{4, 5, 3, u32s(151, 1, 12, 1, 5160, 100)}, // GPSLongitude
+	}
+	ifd0 := func(exifAt, gpsAt uint32) []entry {
+		return []entry{
+			{0x010F, 2, 6, []byte("Canon\x00")},                 // Make
+			{0x0110, 2, 21, []byte("Canon EOS 5D Mark IV\x00")}, // Model
+			{0x0112, 3, 1, u16(uint16(orientation))},            // Orientation
+			{0x8769, 4, 1, u32s(exifAt)},                        // Exif IFD
+			{0x8825, 4, 1, u32s(gpsAt)},                         // GPS IFD
+		}
+	}
+	exifAt := 8 + uint32(len(ifd(8, ifd0(0, 0))))
+	gpsAt := exifAt + uint32(len(ifd(exifAt, exifIFD)))
+	tiff := append([]byte("MM\x00\x2a\x00\x00\x00\x08"), ifd(8, ifd0(exifAt, gpsAt))...)
+	tiff = append(tiff, ifd(exifAt, exifIFD)...)
+	tiff = append(tiff, ifd(gpsAt, gpsIFD)...)
+
+	img := testImage(t, width, height, "jpeg")
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := append([]byte{0xFF, 0xE1}, u16(uint16(len(segment)+2))...)
+	return img[:2] + string(app1) + string(segment) + img[2:]
+}
+
+// memImageDB is an ImageDB held in memory, so the file handling in
+// imageService can be tested without a database
+type memImageDB struct {
+	ImageDB
+	images map[uint]Image
+	metas  map[uint]ImageMeta
+	nextID uint
+}
+
+func (db *memImageDB) MetaByImageID(imageID uint) (*ImageMeta, error) {
+	meta, ok := db.metas[imageID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &meta, nil
+}
+
+func (db *memImageDB) CreateMeta(meta *ImageMeta) error {
+	db.metas[meta.ImageID] = *meta
+	return nil
+}
+
+func (db *memImageDB) ByID(id uint) (*Image, error) {
+	image, ok := db.images[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &image, nil
+}
+
+func (db *memImageDB) Create(image *Image) error {
+	db.nextID++
+	image.ID = db.nextID
+	db.images[image.ID] = *image
+	return nil
+}
+
+func (db *memImageDB) Delete(id uint) error {
+	delete(db.images, id)
+	delete(db.metas, id)
+	return nil
+}
+
+func (db *memImageDB) DeleteByGalleryID(galleryID uint) error {
+	for id, image := range db.images {
+		if image.GalleryID == galleryID {
+			delete(db.images, id)
+			delete(db.metas, id)
+		}
+	}
+	return nil
+}
+
+func TestImageServiceStorage(t *testing.T) {
+	store := &storage.Memory{}
+	db := &memImageDB{images: map[uint]Image{}, metas: map[uint]ImageMeta{}}
+	is := &imageService{
+		db:       &imageValidator{ImageDB: db},
+		store:    store,
+		variants: DefaultImageVariants(),
+	}
+
+	content := testImage(t, 1000, 500, "png")
+	image := Image{GalleryID: 7, Filename: `C:\Users\bozo\My Photo.png`}
+	if err := is.Create(&image, strings.NewReader(content)); err != nil {
+		t.Fatalf("is.Create(): expected nil, got \"%v\"", err)
+	}
+	if image.Filename != "My Photo.png" || image.ContentType != "image/png" || image.Size != int64(len(content)) {
+		t.Errorf("is.Create(): expected \"My Photo.png\", image/png, %d bytes, got %q, %s, %d bytes",
+			len(content), image.Filename, image.ContentType, image.Size)
+	}
+	if got := image.Path(); got != "/galleries/7/images/1/My%20Photo.png" {
+		t.Errorf("Path(): expected \"/galleries/7/images/1/My%%20Photo.png\", got %q", got)
+	}
+	if image.Width != 1000 || image.Height != 500 || image.VariantSizes != "thumb:320x160 medium:800x400" {
+		t.Errorf("is.Create(): expected 1000x500 with thumb and medium variants, got %dx%d with %q",
+			image.Width, image.Height, image.VariantSizes)
+	}
+	for _, key := range []string{"galleries/7/1.png", "galleries/7/1_thumb.png", "galleries/7/1_medium.png"} {
+		if _, err := store.Stat(key); err != nil {
+			t.Errorf("is.Create(): expected a file stored as %q, got \"%v\"", key, err)
+		}
+	}
+
+	rc, err := is.Open(&image, "thumb")
+	if err != nil {
+		t.Fatalf("is.Open(thumb): expected nil, got \"%v\"", err)
+	}
+	thumb, err := png.Decode(rc)
+	rc.Close()
+	if err != nil || thumb.Bounds().Dx() != 320 || thumb.Bounds().Dy() != 160 {
+		t.Errorf("is.Open(thumb): expected a 320x160 PNG, got \"%v\"", err)
+	}
+	if _, err := is.Open(&image, "large"); err != storage.ErrNotFound {
+		t.Errorf("is.Open(large): expected \"%v\", got \"%v\"", storage.ErrNotFound, err)
+	}
+
+	rc, err = is.Open(&image, "")
+	if err != nil {
+		t.Fatalf("is.Open(): expected nil, got \"%v\"", err)
+	}
+	stored, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(stored) != content {
+		t.Errorf("is.Open(): expected the uploaded content, got %q", stored)
+	}
+
+	// refused uploads leave neither a record nor a file behind
+	refused := []struct {
+		desc     string
+		filename string
+		content  string
+		err      error
+	}{
+		{"empty", "bad.png", "", ErrImageTypeInvalid},
+		{"text", "bad.png", "just some text", ErrImageTypeInvalid},
+		{"too large", "bad.png", pngHeader + strings.Repeat("x", imageMaxBytes), ErrImageTooLarge},
+		{"damaged", "bad.png", pngHeader + "not really a PNG", ErrImageUnreadable},
+		{"blank filename", "   ", content, ErrFilenameRequired},
+	}
+	for _, test := range refused {
+		image := Image{GalleryID: 7, Filename: test.filename}
+		if err := is.Create(&image, strings.NewReader(test.content)); err != test.err {
+			t.Errorf("is.Create(%s): expected \"%v\", got \"%v\"", test.desc, test.err, err)
+		}
+	}
+	infos, _ := store.List("galleries/7/")
+	if len(db.images) != 1 || len(infos) != 3 {
+		t.Errorf("is.Create(): expected 1 record and 3 files, got %d and %d", len(db.images), len(infos))
+	}
+
+	if err := is.Delete(&image); err != nil {
+		t.Fatalf("is.Delete(): expected nil, got \"%v\"", err)
+	}
+	if infos, _ := store.List("galleries/7/"); len(infos) != 0 {
+		t.Errorf("is.Delete(): expected the image and its variants deleted, got %+v", infos)
+	}
+	if _, err := is.Open(&image, ""); err != storage.ErrNotFound {
+		t.Errorf("is.Open(deleted): expected \"%v\", got \"%v\"", storage.ErrNotFound, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		image := Image{GalleryID: 8, Filename: "photo.png"}
+		if err := is.Create(&image, strings.NewReader(content)); err != nil {
+			t.Fatalf("is.Create(): expected nil, got \"%v\"", err)
+		}
+	}
+	if err := is.DeleteByGalleryID(8); err != nil {
+		t.Fatalf("is.DeleteByGalleryID(): expected nil, got \"%v\"", err)
+	}
+	if infos, _ := store.List("galleries/8/"); len(infos) != 0 || len(db.images) != 0 {
+		t.Errorf("is.DeleteByGalleryID(): expected no files or records, got %d and %d", len(infos), len(db.images))
+	}
+}
+
+func TestImageCreateByGalleryIDAndDelete(t *testing.T) {
+	if err := services.Image.AutoMigrate(); err != nil {
+		t.Fatalf("is.AutoMigrate(): expected nil, got \"%v\"", err)
+	}
+
+	// random 16-bit integer to use as the test gallery's ID
+	rand.Seed(time.Now().UnixNano())
+	galleryID := uint(rand.Intn(math.MaxUint16)) + 1
+	defer services.Image.DeleteByGalleryID(galleryID)
+
+	content := testImage(t, 10, 10, "png")
+	for _, name := range []string{"first.png", "second.png"} {
+		image := Image{GalleryID: galleryID, Filename: name}
+		if err := services.Image.Create(&image, strings.NewReader(content)); err != nil {
+			t.Fatalf("is.Create(%s): expected nil, got \"%v\"", name, err)
+		}
+	}
+
+	images, err := services.Image.ByGalleryID(galleryID)
+	if err != nil {
+		t.Fatalf("is.ByGalleryID(): expected nil, got \"%v\"", err)
+	}
+	if len(images) != 2 || images[0].Filename != "first.png" || images[1].Position <= images[0].Position {
+		t.Fatalf("is.ByGalleryID(): expected first.png then second.png, got %+v", images)
+	}
+
+	if err := services.Image.Delete(&images[0]); err != nil {
+		t.Fatalf("is.Delete(): expected nil, got \"%v\"", err)
+	}
+	if _, err := services.Image.ByID(images[0].ID); err != ErrNotFound {
+		t.Errorf("is.ByID(deleted): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+}
+
+func TestImageVariantPaths(t *testing.T) {
+	is := &imageService{
+		db:       &imageValidator{ImageDB: &memImageDB{images: map[uint]Image{}, metas: map[uint]ImageMeta{}}},
+		store:    &storage.Memory{},
+		variants: DefaultImageVariants(),
+	}
+	image := Image{GalleryID: 3, Filename: "beach.jpg"}
+	if err := is.Create(&image, strings.NewReader(testImage(t, 1200, 900, "jpeg"))); err != nil {
+		t.Fatalf("is.Create(): expected nil, got \"%v\"", err)
+	}
+
+	if got := image.VariantContentType("medium"); got != "image/jpeg" {
+		t.Errorf("VariantContentType(medium): expected image/jpeg, got %q", got)
+	}
+	if got := image.VariantPath("medium"); got != "/galleries/3/images/1/medium/beach.jpg" {
+		t.Errorf("VariantPath(medium): expected \"/galleries/3/images/1/medium/beach.jpg\", got %q", got)
+	}
+	// large would be bigger than the original, so the original is used
+	if got := image.VariantPath("large"); got != image.Path() {
+		t.Errorf("VariantPath(large): expected %q, got %q", image.Path(), got)
+	}
+	expected := "/galleries/3/images/1/thumb/beach.jpg 320w, " +
+		"/galleries/3/images/1/medium/beach.jpg 800w, " +
+		"/galleries/3/images/1/beach.jpg 1200w"
+	if got := image.Srcset(); got != expected {
+		t.Errorf("Srcset(): expected %q, got %q", expected, got)
+	}
+}
+
+func TestImageMeta(t *testing.T) {
+	db := &memImageDB{images: map[uint]Image{}, metas: map[uint]ImageMeta{}}
+	is := &imageService{
+		db:       &imageValidator{ImageDB: db},
+		store:    &storage.Memory{},
+		variants: DefaultImageVariants(),
+	}
+
+	// stored on its side, so it is turned clockwise to stand upright
+	image := Image{GalleryID: 5, Filename: "portrait.jpg"}
+	if err := is.Create(&image, strings.NewReader(exifJPEG(t, 1200, 800, 6))); err != nil {
+		t.Fatalf("is.Create(): expected nil, got \"%v\"", err)
+	}
+	if image.Width != 800 || image.Height != 1200 {
+		t.Errorf("is.Create(): expected 800x1200 once turned, got %dx%d", image.Width, image.Height)
+	}
+	rc, err := is.Open(&image, "")
+	if err != nil {
+		t.Fatalf("is.Open(): expected nil, got \"%v\"", err)
+	}
+	cfg, err := jpeg.DecodeConfig(rc)
+	rc.Close()
+	if err != nil || cfg.Width != 800 || cfg.Height != 1200 {
+		t.Errorf("is.Open(): expected an 800x1200 JPEG, got %dx%d, \"%v\"", cfg.Width, cfg.Height, err)
+	}
+
+	meta, err := is.Meta(&image)
+	if err != nil {
+		t.Fatalf("is.Meta(): expected nil, got \"%v\"", err)
+	}
+	if got := meta.Camera(); got != "Canon EOS 5D Mark IV" {
+		t.Errorf("Camera(): expected \"Canon EOS 5D Mark IV\", got %q", got)
+	}
+	if got := meta.Exposure(); got != "1/250 s at f/2.8, ISO 400, 50 mm" {
+		t.Errorf("Exposure(): expected \"1/250 s at f/2.8, ISO 400, 50 mm\", got %q", got)
+	}
+	if taken := time.Date(2019, 7, 14, 16, 32, 5, 0, time.UTC); meta.TakenAt == nil || !meta.TakenAt.Equal(taken) {
+		t.Errorf("is.Meta(): expected taken at %v, got %v", taken, meta.TakenAt)
+	}
+	if got := meta.Location(); got != "-33.859833, 151.214333" {
+		t.Errorf("Location(): expected \"-33.859833, 151.214333\", got %q", got)
+	}
+
+	// images without EXIF data have no metadata, and are kept as is
+	plain := Image{GalleryID: 5, Filename: "plain.png"}
+	content := testImage(t, 30, 20, "png")
+	if err := is.Create(&plain, strings.NewReader(content)); err != nil {
+		t.Fatalf("is.Create(plain): expected nil, got \"%v\"", err)
+	}
+	if _, err := is.Meta(&plain); err != ErrNotFound {
+		t.Errorf("is.Meta(plain): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+	if plain.Width != 30 || plain.Size != int64(len(content)) {
+		t.Errorf("is.Create(plain): expected 30 pixels wide and unchanged, got %d wide and %d bytes", plain.Width, plain.Size)
+	}
+
+	if err := is.Delete(&image); err != nil {
+		t.Fatalf("is.Delete(): expected nil, got \"%v\"", err)
+	}
+	if _, err := is.Meta(&image); err != ErrNotFound {
+		t.Errorf("is.Meta(deleted): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+}
+
+func TestImageMetaFormatting(t *testing.T) {
+	var tests = []struct {
+		meta     ImageMeta
+		camera   string
+		exposure string
+	}{
+		{ImageMeta{CameraMake: "NIKON CORPORATION", CameraModel: "NIKON D850", ExposureTime: "2.5"},
+			"NIKON CORPORATION NIKON D850", "2.5 s"},
+		{ImageMeta{CameraMake: "Apple", CameraModel: "iPhone 11", FNumber: 1.8, FocalLength: 4.25},
+			"Apple iPhone 11", "f/1.8, 4.25 mm"},
+		{ImageMeta{CameraModel: "Pixel 3", ISO: 100}, "Pixel 3", "ISO 100"},
+		{ImageMeta{}, "", ""},
+	}
+	for _, test := range tests {
+		if got := test.meta.Camera(); got != test.camera {
+			t.Errorf("Camera(): expected %q, got %q", test.camera, got)
+		}
+		if got := test.meta.Exposure(); got != test.exposure {
+			t.Errorf("Exposure(): expected %q, got %q", test.exposure, got)
+		}
+	}
+
+	if got := normalizeMetaText("  Canon\x00\x01 EOS\xff  "); got != "Canon EOS" {
+		t.Errorf("normalizeMetaText(): expected \"Canon EOS\", got %q", got)
+	}
+}
+
+func TestImageLimitErrors(t *testing.T) {
+	if want := fmt.Sprintf("%d MB", imageMaxBytes>>20); !strings.Contains(ErrImageTooLarge.Public(), want) {
+		t.Errorf("ErrImageTooLarge.Public(): got %q, want it to mention %q", ErrImageTooLarge.Public(), want)
+	}
+	if want := fmt.Sprintf("%d megapixels", imageMaxPixels/1000000); !strings.Contains(ErrImageTooManyPixels.Public(), want) {
+		t.Errorf("ErrImageTooManyPixels.Public(): got %q, want it to mention %q", ErrImageTooManyPixels.Public(), want)
+	}
+}
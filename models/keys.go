@@ -0,0 +1,33 @@
+package models
+
+import "github.com/peterpla/webdevgo/hash"
+
+// Development secrets, used when no keys are configured. Their key IDs
+// are empty, so the digests and password hashes made with them are
+// the same as those made before keys were versioned.
+const hmacSecretKey = "secret-hmac-key"
+const userPwPepper = "secret-random-string"
+
+// Keys holds the versioned secrets used by the services. To rotate a
+// secret, add a new current key to its keyring and keep the old one
+// until everything hashed with it has been re-keyed: remember tokens
+// and passwords are re-keyed as they are used; password reset tokens,
+// email links and recovery codes should simply be allowed to expire
+// or be used up.
+type Keys struct {
+	// HMAC keys hash tokens and sign links
+	HMAC *hash.Keyring
+
+	// Pepper keys are appended to passwords before hashing
+	Pepper *hash.Keyring
+}
+
+// DefaultKeys returns Keys holding only the development secrets
+func DefaultKeys() Keys {
+	hmacKeys, _ := hash.NewKeyring(hash.Key{Secret: hmacSecretKey})
+	peppers, _ := hash.NewKeyring(hash.Key{Secret: userPwPepper})
+	return Keys{
+		HMAC:   hmacKeys,
+		Pepper: peppers,
+	}
+}
@@ -0,0 +1,320 @@
+package models
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Failed logins are counted separately for each email address and for
+// each client IP. After loginFreeAttempts failures, each further attempt
+// must wait twice as long as the one before (up to loginBackoffMax).
+// Reaching the lockout threshold locks the email address or IP out for
+// loginLockoutDuration, after which the count starts again. Failures
+// are forgotten loginFailureWindow after the most recent one.
+const (
+	loginFreeAttempts     = 3
+	loginBackoffBase      = time.Second
+	loginBackoffMax       = time.Minute
+	emailLockoutThreshold = 10
+	ipLockoutThreshold    = 50
+	loginLockoutDuration  = 15 * time.Minute
+	loginFailureWindow    = time.Hour
+)
+
+// Lockout scopes, recorded with each Lockout
+const (
+	LockoutScopeEmail = "email"
+	LockoutScopeIP    = "ip"
+)
+
+// ErrAccountLocked is returned when there have been too many
+// failed login attempts for an email address or from an IP
+var ErrAccountLocked modelError = "models: too many failed login attempts, please try again later"
+
+// Lockout is a record of an email address or IP being locked out
+// after too many failed logins, kept so administrators can see who
+// is being targeted. Email and IP are those of the attempt that
+// triggered the lockout.
+type Lockout struct {
+	gorm.Model
+	Scope    string `gorm:"not null"`
+	Email    string `gorm:"index"`
+	IP       string `gorm:"index"`
+	Failures int
+	Until    time.Time
+}
+
+// loginFailure tracks recent failed logins for one email address or IP.
+// Key is the scope and value, e.g. "email:bozo@clown.net".
+type loginFailure struct {
+	gorm.Model
+	Key         string `gorm:"not null;unique_index"`
+	Count       int
+	LastAt      time.Time
+	LockedUntil time.Time
+}
+
+// LoginThrottleService limits how quickly logins can be attempted for
+// any one email address or from any one IP
+type LoginThrottleService interface {
+	// Allow returns ErrAccountLocked if the email address or IP is
+	// locked out, or must wait longer before trying again. Otherwise
+	// the attempt is counted as failed until Passed or Succeeded says
+	// it wasn't, so attempts made in parallel can't all be allowed
+	// before their failures are recorded. It should be called before
+	// checking the password or code.
+	Allow(email, ip string) error
+
+	// Failed records that the attempt counted by Allow failed,
+	// locking out the email address or IP if it has reached its
+	// threshold
+	Failed(email, ip string) error
+
+	// Passed takes back the attempt counted by Allow, for a correct
+	// password when a second factor must still be checked. Earlier
+	// failures are kept, so the second factor can't be guessed by
+	// repeating the password step.
+	Passed(email, ip string) error
+
+	// Succeeded forgets the email address's failed logins once the
+	// user has signed in, and takes back the IP's count of the
+	// attempt. The IP's other failures are kept, since one success
+	// says nothing about attempts made against other accounts.
+	Succeeded(email, ip string) error
+
+	// Lockouts returns the lockouts that started after since,
+	// most recent first
+	Lockouts(since time.Time) ([]Lockout, error)
+
+	// Migration helpers
+	AutoMigrate() error
+	DestructiveReset() error
+}
+
+type loginThrottleService struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+// NewLoginThrottleService returns a LoginThrottleService INTERFACE that
+// other packages will use to throttle login attempts
+func NewLoginThrottleService(db *gorm.DB) LoginThrottleService {
+	return &loginThrottleService{
+		db:  db,
+		now: time.Now,
+	}
+}
+
+// throttleKey is one of the keys a login attempt is counted against
+type throttleKey struct {
+	scope     string
+	key       string
+	threshold int
+}
+
+// keys returns the keys a login attempt is counted against
+func (lts *loginThrottleService) keys(email, ip string) []throttleKey {
+	email = strings.ToLower(strings.TrimSpace(email))
+	return []throttleKey{
+		{LockoutScopeEmail, LockoutScopeEmail + ":" + email, emailLockoutThreshold},
+		{LockoutScopeIP, LockoutScopeIP + ":" + ip, ipLockoutThreshold},
+	}
+}
+
+// Allow checks every key for the attempt, and counts it against them
+func (lts *loginThrottleService) Allow(email, ip string) error {
+	now := lts.now()
+	return lts.update(lts.keys(email, ip), func(tx *gorm.DB, k throttleKey, lf *loginFailure) error {
+		if !lf.attempt(now, k.threshold) {
+			return ErrAccountLocked
+		}
+		return nil
+	})
+}
+
+// Failed locks out every key for the attempt that has reached its
+// threshold, recording a Lockout for each
+func (lts *loginThrottleService) Failed(email, ip string) error {
+	now := lts.now()
+	email = strings.ToLower(strings.TrimSpace(email))
+	return lts.update(lts.keys(email, ip), func(tx *gorm.DB, k throttleKey, lf *loginFailure) error {
+		if !lf.fail(now, k.threshold) {
+			return nil
+		}
+		lockout := Lockout{
+			Scope:    k.scope,
+			Email:    email,
+			IP:       ip,
+			Failures: lf.Count,
+			Until:    lf.LockedUntil,
+		}
+		log.Printf("login lockout: %s %q until %s after %d failures",
+			k.scope, strings.TrimPrefix(k.key, k.scope+":"),
+			lockout.Until.Format(time.RFC3339), lockout.Failures)
+		return tx.Create(&lockout).Error
+	})
+}
+
+// Passed takes the attempt back from every key
+func (lts *loginThrottleService) Passed(email, ip string) error {
+	return lts.update(lts.keys(email, ip), func(tx *gorm.DB, k throttleKey, lf *loginFailure) error {
+		lf.release()
+		return nil
+	})
+}
+
+// Succeeded deletes the email address's failure record, and takes the
+// attempt back from the IP's
+func (lts *loginThrottleService) Succeeded(email, ip string) error {
+	keys := lts.keys(email, ip)
+	err := lts.db.Unscoped().Where("key = ?", keys[0].key).Delete(&loginFailure{}).Error
+	if err != nil {
+		return err
+	}
+	return lts.update(keys[1:], func(tx *gorm.DB, k throttleKey, lf *loginFailure) error {
+		lf.release()
+		return nil
+	})
+}
+
+// Lockouts returns recent lockouts
+func (lts *loginThrottleService) Lockouts(since time.Time) ([]Lockout, error) {
+	var lockouts []Lockout
+	db := lts.db.Where("created_at > ?", since).Order("created_at desc")
+	if err := db.Find(&lockouts).Error; err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// update calls fn with the failure record of each key, creating any
+// that are missing, and saves them. It runs in a transaction holding
+// the records' row locks, so concurrent attempts are counted one at a
+// time; the keys are always locked in the same order, so attempts
+// can't deadlock. If fn returns an error, nothing is saved.
+func (lts *loginThrottleService) update(keys []throttleKey,
+	fn func(tx *gorm.DB, k throttleKey, lf *loginFailure) error) error {
+	tx := lts.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	for _, k := range keys {
+		now := lts.now()
+		err := tx.Exec(`INSERT INTO login_failures (created_at, updated_at, key, count, last_at, locked_until)
+			VALUES (?, ?, ?, 0, ?, ?) ON CONFLICT (key) DO NOTHING`,
+			now, now, k.key, time.Time{}, time.Time{}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		var lf loginFailure
+		err = first(tx.Set("gorm:query_option", "FOR UPDATE").Where("key = ?", k.key), &lf)
+		if err == nil {
+			err = fn(tx, k, &lf)
+		}
+		if err == nil {
+			err = tx.Save(&lf).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// DestructiveReset drops the login throttling tables and rebuilds them
+func (lts *loginThrottleService) DestructiveReset() error {
+	err := lts.db.DropTableIfExists(&loginFailure{}, &Lockout{}).Error
+	if err != nil {
+		return err
+	}
+	return lts.AutoMigrate()
+}
+
+// AutoMigrate will attempt to automaticaly migrate
+// the login throttling tables
+func (lts *loginThrottleService) AutoMigrate() error {
+	if err := lts.db.AutoMigrate(&loginFailure{}, &Lockout{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+/* ********** ********** ********** */
+/*       loginFailure helpers       */
+
+// expired reports whether the failures are old enough to be forgotten
+func (lf *loginFailure) expired(now time.Time) bool {
+	return now.After(lf.LockedUntil) && now.Sub(lf.LastAt) > loginFailureWindow
+}
+
+// settle forgets the failures once they have expired, or once the
+// lockout they caused is over, so the next failure doesn't lock the
+// key out again straight away
+func (lf *loginFailure) settle(now time.Time) {
+	lockOver := !lf.LockedUntil.IsZero() && !now.Before(lf.LockedUntil)
+	if lf.expired(now) || lockOver {
+		lf.Count = 0
+		lf.LockedUntil = time.Time{}
+	}
+}
+
+// allows reports whether another attempt may be made at now. Attempts
+// still being checked are counted, so a key at its threshold allows no
+// more even before the last failure is recorded.
+func (lf *loginFailure) allows(now time.Time, threshold int) bool {
+	lf.settle(now)
+	if now.Before(lf.LockedUntil) || lf.Count >= threshold {
+		return false
+	}
+	return !now.Before(lf.LastAt.Add(loginBackoff(lf.Count)))
+}
+
+// attempt counts an attempt made at now, reporting whether it is
+// allowed; refused attempts aren't counted
+func (lf *loginFailure) attempt(now time.Time, threshold int) bool {
+	if !lf.allows(now, threshold) {
+		return false
+	}
+	lf.Count++
+	lf.LastAt = now
+	return true
+}
+
+// fail locks the key out if its failures have reached threshold,
+// reporting whether it wasn't locked out already
+func (lf *loginFailure) fail(now time.Time, threshold int) bool {
+	if now.Before(lf.LockedUntil) || lf.Count < threshold {
+		return false
+	}
+	lf.LockedUntil = now.Add(loginLockoutDuration)
+	return true
+}
+
+// release takes back an attempt that didn't fail
+func (lf *loginFailure) release() {
+	if lf.Count > 0 {
+		lf.Count--
+	}
+}
+
+// loginBackoff returns how long to wait after the given number of
+// consecutive failures before another attempt is allowed
+func loginBackoff(failures int) time.Duration {
+	if failures < loginFreeAttempts {
+		return 0
+	}
+	wait := loginBackoffBase
+	for i := loginFreeAttempts; i < failures; i++ {
+		wait *= 2
+		if wait >= loginBackoffMax {
+			return loginBackoffMax
+		}
+	}
+	return wait
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{loginFreeAttempts - 1, 0},
+		{loginFreeAttempts, loginBackoffBase},
+		{loginFreeAttempts + 1, 2 * loginBackoffBase},
+		{loginFreeAttempts + 3, 8 * loginBackoffBase},
+		{emailLockoutThreshold * 10, loginBackoffMax},
+	}
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures); got != tt.want {
+			t.Errorf("loginBackoff(%d): expected %v, got %v", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestLoginFailureAllows(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		lf   loginFailure
+		want bool
+	}{
+		{"few failures", loginFailure{Count: 1, LastAt: now}, true},
+		{"backing off", loginFailure{Count: loginFreeAttempts + 2, LastAt: now.Add(-time.Second)}, false},
+		{"backoff over", loginFailure{Count: loginFreeAttempts + 2, LastAt: now.Add(-5 * time.Second)}, true},
+		{"at threshold", loginFailure{Count: emailLockoutThreshold, LastAt: now.Add(-2 * loginBackoffMax)}, false},
+		{"locked", loginFailure{Count: emailLockoutThreshold, LastAt: now.Add(-2 * loginBackoffMax), LockedUntil: now.Add(time.Minute)}, false},
+		{"lock over", loginFailure{Count: emailLockoutThreshold, LastAt: now.Add(-2 * loginBackoffMax), LockedUntil: now.Add(-time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.lf.allows(now, emailLockoutThreshold); got != tt.want {
+			t.Errorf("%s: allows(): expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	old := loginFailure{Count: emailLockoutThreshold, LastAt: now.Add(-2 * loginFailureWindow)}
+	if !old.expired(now) {
+		t.Errorf("failures older than loginFailureWindow: expected expired")
+	}
+}
+
+func TestLoginFailureAttempts(t *testing.T) {
+	now := time.Now()
+	var lf loginFailure
+
+	// attempts are counted as they're allowed, so parallel attempts
+	// run into the backoff before any of them has failed
+	for i := 1; i <= loginFreeAttempts; i++ {
+		if !lf.attempt(now, emailLockoutThreshold) {
+			t.Fatalf("attempt() %d: expected it allowed", i)
+		}
+	}
+	if lf.attempt(now, emailLockoutThreshold) || lf.Count != loginFreeAttempts {
+		t.Errorf("attempt() during backoff: expected it refused and not counted, got count %d", lf.Count)
+	}
+
+	// an attempt that didn't fail is taken back
+	lf.release()
+	if lf.Count != loginFreeAttempts-1 {
+		t.Errorf("release(): expected count %d, got %d", loginFreeAttempts-1, lf.Count)
+	}
+
+	// reaching the threshold locks the key out once
+	lf.Count = emailLockoutThreshold
+	if !lf.fail(now, emailLockoutThreshold) {
+		t.Fatalf("fail() at threshold: expected a lockout")
+	}
+	if !lf.LockedUntil.Equal(now.Add(loginLockoutDuration)) {
+		t.Errorf("fail(): expected locked until %v, got %v", now.Add(loginLockoutDuration), lf.LockedUntil)
+	}
+	if lf.fail(now, emailLockoutThreshold) {
+		t.Errorf("fail() while locked: expected no second lockout")
+	}
+
+	// once the lockout is over the count starts again, so one more
+	// failure doesn't lock the key out straight away
+	later := lf.LockedUntil.Add(time.Second)
+	if !lf.attempt(later, emailLockoutThreshold) {
+		t.Fatalf("attempt() after lockout: expected it allowed")
+	}
+	if lf.Count != 1 || !lf.LockedUntil.IsZero() {
+		t.Errorf("attempt() after lockout: expected count 1 and no lockout, got %d, %v", lf.Count, lf.LockedUntil)
+	}
+	if lf.fail(later, emailLockoutThreshold) {
+		t.Errorf("fail() after lockout: expected no new lockout")
+	}
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// pwResetDuration is how long a password reset token remains valid
+const pwResetDuration = time.Hour
+
+// pwReset is a single outstanding password reset request. As with
+// sessions, only the HMAC of the emailed token is stored.
+type pwReset struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null"`
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not null;unique_index"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// a compile-time error below indicates the pwResetGorm type no longer
+// matches the pwResetDB interface. They should match.
+var _ pwResetDB = &pwResetGorm{}
+
+// pwResetDB is used to interact with the password resets database.
+// It is only used by the userService, so it is not exported.
+type pwResetDB interface {
+	ByToken(token string) (*pwReset, error)
+	Create(pwr *pwReset) error
+	Delete(id uint) error
+}
+
+// pwResetGorm represents our database interaction layer
+// and implements the pwResetDB interface fully
+type pwResetGorm struct {
+	db *gorm.DB
+}
+
+// pwResetValidator is our validation/normalization layer for
+// password resets
+type pwResetValidator struct {
+	pwResetDB
+	hmac hash.HMAC
+}
+
+// newPwResetValidator returns a pointer to a pwResetValidator instance
+func newPwResetValidator(db pwResetDB, hmac hash.HMAC) *pwResetValidator {
+	return &pwResetValidator{
+		pwResetDB: db,
+		hmac:      hmac,
+	}
+}
+
+/* ********** ********** ********** */
+/*         pwResetGorm methods      */
+
+// ByToken looks up a password reset by token hash
+func (pwrg *pwResetGorm) ByToken(tokenHash string) (*pwReset, error) {
+	var pwr pwReset
+	err := first(pwrg.db.Where("token_hash = ?", tokenHash), &pwr)
+	if err != nil {
+		return nil, err
+	}
+	return &pwr, nil
+}
+
+// Create expects the password reset to be validated and normalized,
+// and will create the password reset database record
+func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+	return pwrg.db.Create(pwr).Error
+}
+
+// Delete will delete the password reset with the provided ID
+func (pwrg *pwResetGorm) Delete(id uint) error {
+	pwr := pwReset{Model: gorm.Model{ID: id}}
+	return pwrg.db.Unscoped().Delete(&pwr).Error
+}
+
+/* ********** ********** ********** */
+/*     pwResetValidator methods     */
+
+// Create will generate the reset token and its hash, and set the
+// expiry, then pass to the database layer to create the record. The
+// Token field is left populated so the caller can send it to the user.
+func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+	err := runPwResetValFns(pwr,
+		pwrv.requireUserID,
+		pwrv.setTokenIfUnset,
+		pwrv.hmacToken, // after setTokenIfUnset - sequence matters!
+		pwrv.setExpiryIfUnset)
+	if err != nil {
+		return err
+	}
+	return pwrv.pwResetDB.Create(pwr)
+}
+
+// ByToken normalization: hash the reset token with each HMAC key in
+// turn and pass it to pwResetDB's ByToken until a reset is found.
+// Resets are short-lived, so one found by an older key isn't re-keyed.
+func (pwrv *pwResetValidator) ByToken(token string) (*pwReset, error) {
+	if token == "" {
+		return nil, ErrTokenInvalid
+	}
+	for _, tokenHash := range pwrv.hmac.Hashes(token) {
+		pwr, err := pwrv.pwResetDB.ByToken(tokenHash)
+		if err != ErrNotFound {
+			return pwr, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Delete will validate the provided ID, then pass to the database
+// layer to delete the record
+func (pwrv *pwResetValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return pwrv.pwResetDB.Delete(id)
+}
+
+// ensure the reset belongs to a user
+func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+	if pwr.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+// setTokenIfUnset ensures the reset has a token
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+	if pwr.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	pwr.Token = token
+	return nil
+}
+
+// hmacToken calculates and stores the reset token hash
+func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+	if pwr.Token == "" {
+		return ErrTokenInvalid
+	}
+	pwr.TokenHash = pwrv.hmac.Hash(pwr.Token)
+	return nil
+}
+
+// a new reset expires pwResetDuration after it is created
+func (pwrv *pwResetValidator) setExpiryIfUnset(pwr *pwReset) error {
+	if pwr.ExpiresAt.IsZero() {
+		pwr.ExpiresAt = time.Now().Add(pwResetDuration)
+	}
+	return nil
+}
+
+/* ********** ********** ********** */
+/*     pwResetValidator helpers     */
+
+// all password reset validation/normalization functions implement
+// this signature to simplify runPwResetValFns
+type pwResetValFn func(*pwReset) error
+
+// iterate through the sequence of pwResetValFn-conforming validation/normalization functions
+func runPwResetValFns(pwr *pwReset, fns ...pwResetValFn) error {
+	for _, fn := range fns {
+		if err := fn(pwr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,15 +1,26 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"github.com/jinzhu/gorm"
+
+	"github.com/peterpla/webdevgo/password"
+	"github.com/peterpla/webdevgo/storage"
+)
 
 // Services holds service details fro each of our services
 type Services struct {
-	Gallery GalleryService
-	User UserService
+	Gallery       GalleryService
+	Image         ImageService
+	LoginThrottle LoginThrottleService
+	Session       SessionService
+	TwoFactor     TwoFactorService
+	User          UserService
 }
 
-// NewServices opens the database connection and initializes each service
-func NewServices(connectionInfo string) (*Services, error) {
+// NewServices opens the database connection and initializes each
+// service, using keys for hashing and signing, requiring new
+// passwords to satisfy policy, and keeping image files in images
+func NewServices(connectionInfo string, keys Keys, policy password.Policy, images storage.Store) (*Services, error) {
 	// open the database connection
 	db, err := gorm.Open("postgres", connectionInfo)
 	if err != nil {
@@ -17,10 +28,17 @@ func NewServices(connectionInfo string) (*Services, error) {
 	}
 	db.LogMode(true)
 
-	// initialize the User and Gallery services
+	// initialize the Session, User, TwoFactor, LoginThrottle,
+	// Gallery and Image services
+	ss := NewSessionService(db, keys)
+	us := NewUserService(db, ss, keys, policy)
 	s := &Services{
-		User: NewUserService(db),
-		Gallery: &galleryGorm{},
+		Session:       ss,
+		User:          us,
+		TwoFactor:     NewTwoFactorService(db, us, keys),
+		LoginThrottle: NewLoginThrottleService(db),
+		Gallery:       NewGalleryService(db),
+		Image:         NewImageService(db, images, DefaultImageVariants()),
 	}
 	return s, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,436 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// sessionTouchInterval limits how often a session's LastSeenAt is
+// written back to the database, so every request doesn't cost a write
+const sessionTouchInterval = time.Minute
+
+// sessionMaxAge is how long a session lasts after signing in, the same
+// as the browser keeps the remember_token cookie (cookie.Remember).
+// The expiry is enforced here too, so a leaked token stops working.
+// It doesn't slide, since the cookie's expiry doesn't either.
+const sessionMaxAge = 30 * 24 * time.Hour
+
+// Session is a single signed-in device. The remember token stored in
+// the device's cookie is never saved; only its HMAC is. Sessions
+// can't be used after ExpiresAt.
+type Session struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Expired reports whether the session has expired at now. Sessions
+// created before ExpiresAt was recorded expire sessionMaxAge after
+// they were created.
+func (s *Session) Expired(now time.Time) bool {
+	expires := s.ExpiresAt
+	if expires.IsZero() {
+		expires = s.CreatedAt.Add(sessionMaxAge)
+	}
+	return !now.Before(expires)
+}
+
+// SessionService interface methods are used to work with the session model
+type SessionService interface {
+	// Touch records that the session was just used. To limit database
+	// writes, LastSeenAt is only updated if it is older than
+	// sessionTouchInterval.
+	Touch(session *Session) error
+
+	// DeleteOthers deletes every session belonging to the user with
+	// the provided ID except keepID, signing them out everywhere else
+	DeleteOthers(userID uint, keepID uint) error
+	SessionDB
+}
+
+// a compile-time error below indicates the sessionGorm type no longer
+// matches the SessionDB interface. They should match.
+var _ SessionDB = &sessionGorm{}
+
+// a compile-time error below indicates the sessionValidator type no longer
+// matches the SessionDB interface. They should match.
+var _ SessionDB = &sessionValidator{}
+
+// SessionDB is used to interact with the sessions database
+//
+// For single session queries:
+// If the session is found, return the session and nil
+// If the session is not found, return nil and ErrNotFound
+// If another error occurs, return the error we receive, which
+// may not be an error generated by the models package.
+type SessionDB interface {
+	// Methods for querying sessions
+	ByID(id uint) (*Session, error)
+	ByToken(token string) (*Session, error)
+	ByUserID(userID uint) ([]Session, error)
+
+	// Methods for altering sessions
+	Create(session *Session) error
+	Update(session *Session) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+
+	// Migration helpers
+	AutoMigrate() error
+	DestructiveReset() error
+}
+
+// sessionGorm represents our database interaction layer
+// and implements the SessionDB interface fully
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+// sessionValidator is our validation/normalization layer that
+// validates and normalizes session data before passing it along
+// our interface chain
+type sessionValidator struct {
+	SessionDB
+	hmac hash.HMAC
+}
+
+type sessionService struct {
+	SessionDB
+}
+
+// NewSessionService returns a SessionService INTERFACE that other
+// packages will use to access the sessions database.
+func NewSessionService(db *gorm.DB, keys Keys) SessionService {
+	hmac := hash.NewKeyedHMAC(keys.HMAC)
+	return &sessionService{
+		SessionDB: &sessionValidator{
+			SessionDB: &sessionGorm{db},
+			hmac:      hmac,
+		},
+	}
+}
+
+/* ********** ********** ********** */
+/*       sessionService methods     */
+
+// Touch updates the session's LastSeenAt, at most once
+// per sessionTouchInterval
+func (ss *sessionService) Touch(session *Session) error {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	session.LastSeenAt = now
+	return ss.Update(session)
+}
+
+// DeleteOthers deletes all of the user's sessions except keepID
+func (ss *sessionService) DeleteOthers(userID uint, keepID uint) error {
+	sessions, err := ss.ByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == keepID {
+			continue
+		}
+		if err := ss.Delete(session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* ********** ********** ********** */
+/*         sessionGorm methods      */
+
+// ByID will look up a session with the provided ID
+func (sg *sessionGorm) ByID(id uint) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("id = ?", id), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ByToken looks up a session by remember token hash
+func (sg *sessionGorm) ByToken(tokenHash string) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("token_hash = ?", tokenHash), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ByUserID returns all sessions belonging to the user with the
+// provided ID, most recently used first
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	db := sg.db.Where("user_id = ?", userID).Order("last_seen_at desc")
+	if err := db.Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Create expects the session fields to be validated and normalized,
+// and will create the session database record
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+// Update expects the session fields to be validated and normalized,
+// and will update the session's DB record
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Save(session).Error
+}
+
+// Delete expects the session ID to be validated, and will
+// delete the session with the provided ID
+func (sg *sessionGorm) Delete(id uint) error {
+	session := Session{Model: gorm.Model{ID: id}}
+	return sg.db.Unscoped().Delete(&session).Error
+}
+
+// DeleteByUserID deletes every session belonging to the user
+// with the provided ID, signing them out on all devices
+func (sg *sessionGorm) DeleteByUserID(userID uint) error {
+	return sg.db.Unscoped().Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+// DestructiveReset drops the sessions table and rebuilds it
+func (sg *sessionGorm) DestructiveReset() error {
+	err := sg.db.DropTableIfExists(&Session{}).Error
+	if err != nil {
+		return err
+	}
+	return sg.AutoMigrate()
+}
+
+// AutoMigrate will attempt to automaticaly migrate
+// the sessions table
+func (sg *sessionGorm) AutoMigrate() error {
+	if err := sg.db.AutoMigrate(&Session{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+/* ********** ********** ********** */
+/*     sessionValidator methods     */
+
+// Create will generate the session's remember token (if unset) and
+// its hash, then pass to the database layer to create the session
+// record in the database. The Token field is left populated so the
+// caller can store it in a cookie.
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFns(session,
+		sv.userIDRequired,
+		sv.setTokenIfUnset,
+		sv.tokenMinBytes, // after setTokenIfUnset - sequence matters!
+		sv.hmacToken,
+		sv.tokenHashRequired, // after hmacToken - sequence matters!
+		sv.setLastSeenIfUnset,
+		sv.setExpiryIfUnset)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+// Update will validate the session, then pass to the database layer
+// to update the session record in the database
+func (sv *sessionValidator) Update(session *Session) error {
+	err := runSessionValFns(session,
+		sv.userIDRequired,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired) // after hmacToken - sequence matters!
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Update(session)
+}
+
+// Delete will validate the provided session ID, then pass to the
+// database layer to delete the session record from the database
+func (sv *sessionValidator) Delete(id uint) error {
+	var session Session
+	session.ID = id
+
+	err := runSessionValFns(&session, sv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Delete(id)
+}
+
+// DeleteByUserID will validate the provided user ID, then pass to
+// the database layer to delete the user's sessions
+func (sv *sessionValidator) DeleteByUserID(userID uint) error {
+	session := Session{UserID: userID}
+
+	err := runSessionValFns(&session, sv.userIDRequired)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.DeleteByUserID(userID)
+}
+
+// ByToken normalization: hash the remember token with each HMAC key
+// in turn and pass it to SessionDB's ByToken until a session is found.
+// An expired session is deleted and not found. A session found by an
+// older key is re-keyed with the current one.
+func (sv *sessionValidator) ByToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	for _, tokenHash := range sv.hmac.Hashes(token) {
+		session, err := sv.SessionDB.ByToken(tokenHash)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if session.Expired(time.Now()) {
+			if err := sv.SessionDB.Delete(session.ID); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		if !sv.hmac.IsCurrent(tokenHash) {
+			session.Token = token
+			if err := sv.Update(session); err != nil {
+				return nil, err
+			}
+		}
+		return session, nil
+	}
+	return nil, ErrNotFound
+}
+
+// ByUserID leaves out the user's expired sessions
+func (sv *sessionValidator) ByUserID(userID uint) ([]Session, error) {
+	sessions, err := sv.SessionDB.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	current := sessions[:0]
+	for _, session := range sessions {
+		if !session.Expired(now) {
+			current = append(current, session)
+		}
+	}
+	return current, nil
+}
+
+// ensure the session belongs to a user
+func (sv *sessionValidator) userIDRequired(session *Session) error {
+	if session.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+// setTokenIfUnset ensures the session has a remember token
+func (sv *sessionValidator) setTokenIfUnset(session *Session) error {
+	if session.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	session.Token = token
+	return nil
+}
+
+// ensure remember token is >= 32 bytes
+func (sv *sessionValidator) tokenMinBytes(session *Session) error {
+	if session.Token == "" {
+		// tokens are only known at creation; trust other
+		// validations to catch any errors
+		return nil
+	}
+	n, err := rand.NBytes(session.Token)
+	if err != nil {
+		return err
+	}
+	if n < rand.RememberTokenBytes {
+		return ErrRememberTooShort
+	}
+	return nil
+}
+
+// hmacToken calculates and stores in Session the remember token hash
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = sv.hmac.Hash(session.Token)
+	return nil
+}
+
+// ensure remember token hash is provided
+func (sv *sessionValidator) tokenHashRequired(session *Session) error {
+	if session.TokenHash == "" {
+		return ErrRememberRequired
+	}
+	return nil
+}
+
+// a new session was "last seen" when it was created
+func (sv *sessionValidator) setLastSeenIfUnset(session *Session) error {
+	if session.LastSeenAt.IsZero() {
+		session.LastSeenAt = time.Now()
+	}
+	return nil
+}
+
+// a new session expires sessionMaxAge after it was created
+func (sv *sessionValidator) setExpiryIfUnset(session *Session) error {
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = time.Now().Add(sessionMaxAge)
+	}
+	return nil
+}
+
+// idGreaterThan ensures the ID is greater than the provided argument
+func (sv *sessionValidator) idGreaterThan(n uint) sessionValFn {
+	return sessionValFn(func(session *Session) error {
+		if session.ID <= n {
+			return ErrIDInvalid
+		}
+		return nil
+	})
+}
+
+/* ********** ********** ********** */
+/*     sessionValidator helpers     */
+
+// all session validation/normalization functions implement this signature
+// to simplify runSessionValFns
+type sessionValFn func(*Session) error
+
+// iterate through the sequence of sessionValFn-conforming validation/normalization functions
+func runSessionValFns(session *Session, fns ...sessionValFn) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/peterpla/webdevgo/cookie"
+	"github.com/peterpla/webdevgo/hash"
+)
+
+func TestSessionValidatorToken(t *testing.T) {
+	sv := &sessionValidator{hmac: hash.NewHMAC(hmacSecretKey)}
+
+	type testset struct {
+		session      Session
+		expErr       error
+		expHashEmpty bool
+	}
+
+	var tests = []testset{
+		{Session{UserID: 0}, ErrUserIDRequired, true},
+		{Session{UserID: 1}, nil, false}, // token generated
+		{Session{UserID: 1, Token: "c2hvcnQ="}, ErrRememberTooShort, true},
+	}
+
+	for i, r := range tests {
+		err := runSessionValFns(&r.session,
+			sv.userIDRequired,
+			sv.setTokenIfUnset,
+			sv.tokenMinBytes,
+			sv.hmacToken,
+			sv.tokenHashRequired)
+
+		if err != r.expErr {
+			t.Errorf("test %d: got %v, want %v", i, err, r.expErr)
+		}
+		if r.expHashEmpty != (r.session.TokenHash == "") {
+			t.Errorf("test %d: got TokenHash %q, want empty %t", i, r.session.TokenHash, r.expHashEmpty)
+		}
+	}
+}
+
+func TestSessionCreateByTokenAndRemember(t *testing.T) {
+	if err := services.Session.AutoMigrate(); err != nil {
+		t.Fatalf("ss.AutoMigrate(): expected nil, got \"%v\"", err)
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	user := User{
+		Name:     "Session Test",
+		Email:    fmt.Sprintf("session-test%d@test.com", rand.Intn(math.MaxUint16)),
+		Password: "devicesPASS",
+	}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatalf("us.Create(): expected nil, got \"%v\"", err)
+	}
+	defer services.User.Delete(user.ID)
+
+	// two devices, two independent sessions
+	phone := Session{UserID: user.ID, UserAgent: "phone"}
+	laptop := Session{UserID: user.ID, UserAgent: "laptop"}
+	for _, s := range []*Session{&phone, &laptop} {
+		if err := services.Session.Create(s); err != nil {
+			t.Fatalf("ss.Create(): expected nil, got \"%v\"", err)
+		}
+	}
+	if phone.Token == laptop.Token {
+		t.Fatalf("expected distinct tokens per session, got %q twice", phone.Token)
+	}
+
+	for _, s := range []Session{phone, laptop} {
+		found, err := services.User.ByRemember(s.Token)
+		if err != nil {
+			t.Fatalf("us.ByRemember(%s): expected nil, got \"%v\"", s.UserAgent, err)
+		}
+		if found.ID != user.ID {
+			t.Errorf("us.ByRemember(%s): expected user %d, got %d", s.UserAgent, user.ID, found.ID)
+		}
+	}
+
+	// signing out the phone leaves the laptop signed in
+	if err := services.Session.Delete(phone.ID); err != nil {
+		t.Fatalf("ss.Delete(): expected nil, got \"%v\"", err)
+	}
+	if _, err := services.User.ByRemember(phone.Token); err != ErrNotFound {
+		t.Errorf("us.ByRemember(phone): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+	if _, err := services.User.ByRemember(laptop.Token); err != nil {
+		t.Errorf("us.ByRemember(laptop): expected nil, got \"%v\"", err)
+	}
+
+	// an expired session can't be used, even though the token is right
+	old := Session{UserID: user.ID, UserAgent: "old", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := services.Session.Create(&old); err != nil {
+		t.Fatalf("ss.Create(expired): expected nil, got \"%v\"", err)
+	}
+	if _, err := services.User.ByRemember(old.Token); err != ErrNotFound {
+		t.Errorf("us.ByRemember(expired): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+	if sessions, _ := services.Session.ByUserID(user.ID); len(sessions) != 1 {
+		t.Errorf("ss.ByUserID(): expected only the laptop's session, got %d", len(sessions))
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	if sessionMaxAge != cookie.Remember.MaxAge {
+		t.Errorf("sessionMaxAge: expected it to match the cookie's %v, got %v", cookie.Remember.MaxAge, sessionMaxAge)
+	}
+
+	sv := &sessionValidator{}
+	var session Session
+	before := time.Now()
+	if err := sv.setExpiryIfUnset(&session); err != nil {
+		t.Fatalf("setExpiryIfUnset(): expected nil, got \"%v\"", err)
+	}
+	if session.ExpiresAt.Before(before.Add(sessionMaxAge)) || session.ExpiresAt.After(time.Now().Add(sessionMaxAge)) {
+		t.Errorf("setExpiryIfUnset(): expected about %v from now, got %v", sessionMaxAge, session.ExpiresAt)
+	}
+
+	now := time.Now()
+	type testset struct {
+		name    string
+		session Session
+		expired bool
+	}
+	var tests = []testset{
+		{"current", Session{ExpiresAt: now.Add(time.Minute)}, false},
+		{"expired", Session{ExpiresAt: now.Add(-time.Minute)}, true},
+		{"legacy current", Session{Model: gorm.Model{CreatedAt: now.Add(-time.Hour)}}, false},
+		{"legacy expired", Session{Model: gorm.Model{CreatedAt: now.Add(-sessionMaxAge - time.Hour)}}, true},
+	}
+	for _, r := range tests {
+		if got := r.session.Expired(now); got != r.expired {
+			t.Errorf("%s: Expired(): got %v, want %v", r.name, got, r.expired)
+		}
+	}
+}
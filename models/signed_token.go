@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/peterpla/webdevgo/hash"
+)
+
+// Purposes of the signed tokens issued by the models package
+const (
+	tokenPurposeVerify    = "verify"
+	tokenPurposeTwoFactor = "2fa"
+)
+
+// decodeToken decodes a signed token for the purpose. Users don't need
+// to know why a token was refused, so any problem with it results in
+// ErrTokenInvalid.
+func decodeToken(tc *hash.TokenCodec, token, purpose string) (*hash.Claims, error) {
+	claims, err := tc.Decode(token, purpose)
+	if err != nil {
+		if _, ok := err.(hash.TokenError); ok {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	return claims, nil
+}
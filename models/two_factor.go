@@ -0,0 +1,333 @@
+package models
+
+import (
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/peterpla/webdevgo/hash"
+	"github.com/peterpla/webdevgo/rand"
+	"github.com/peterpla/webdevgo/totp"
+)
+
+const (
+	// totpIssuer names our site in the user's authenticator app
+	totpIssuer = "Whatever.com"
+
+	// totpSkew is how many time steps of clock drift either side
+	// of now we accept codes for
+	totpSkew = 1
+
+	// twoFactorLoginDuration is how long a user has to enter their
+	// code after entering their password
+	twoFactorLoginDuration = 5 * time.Minute
+
+	// recoveryCodeCount is how many recovery codes a user gets
+	recoveryCodeCount = 10
+
+	// recoveryCodeBytes is the amount of randomness in each
+	// recovery code, which encodes to 8 base32 characters
+	recoveryCodeBytes = 5
+)
+
+// ErrTwoFactorCodeInvalid is returned when an authenticator app code
+// or recovery code is wrong, expired or has already been used
+var ErrTwoFactorCodeInvalid modelError = "models: two-factor code is not valid"
+
+// ErrTwoFactorEnabled is returned when enrollment is confirmed for a
+// user who already has two-factor authentication enabled
+var ErrTwoFactorEnabled modelError = "models: two-factor authentication is already enabled"
+
+// recoveryCodeEncoding is used to turn random bytes into recovery codes
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorEnabled reports whether the user must enter an
+// authenticator app code (or recovery code) to log in
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// recoveryCode is a single-use code a user can enter instead of an
+// authenticator app code. Only the HMAC of the code is stored.
+type recoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	CodeHash string `gorm:"not null;unique_index"`
+}
+
+// TwoFactorService is used to enroll users in TOTP two-factor
+// authentication, and to complete the second step of logging in
+type TwoFactorService interface {
+	// BeginEnrollment generates and saves a new TOTP secret for the
+	// user. Two-factor authentication is not enabled until the user
+	// proves their app has the secret with ConfirmEnrollment.
+	BeginEnrollment(user *User) error
+
+	// ProvisioningURI returns the otpauth:// URI for the user's
+	// secret, to be shown as a QR code or link
+	ProvisioningURI(user *User) string
+
+	// ConfirmEnrollment enables two-factor authentication if code is
+	// valid for the user's pending secret, and returns a fresh set of
+	// recovery codes to show the user once. It fails with
+	// ErrTwoFactorEnabled if the user has already enabled it.
+	ConfirmEnrollment(user *User, code string) ([]string, error)
+
+	// Disable turns off two-factor authentication for the user and
+	// discards their secret and recovery codes
+	Disable(user *User) error
+
+	// RegenerateRecoveryCodes replaces the user's recovery codes,
+	// returning the new ones to show the user once
+	RegenerateRecoveryCodes(user *User) ([]string, error)
+
+	// RecoveryCodesLeft returns how many unused recovery codes the user has
+	RecoveryCodesLeft(user *User) (int, error)
+
+	// LoginToken returns a short-lived token showing the user has
+	// passed the password step of logging in
+	LoginToken(user *User) (string, error)
+
+	// LoginUser returns the user named by a login token, without
+	// checking a code, so failed codes can be counted against them.
+	// An invalid or expired token results in ErrTokenInvalid.
+	LoginUser(token string) (*User, error)
+
+	// CompleteLogin checks the login token and the authenticator app
+	// or recovery code, and returns the user if both are valid.
+	// An invalid or expired token results in ErrTokenInvalid, and a
+	// wrong or reused code in ErrTwoFactorCodeInvalid.
+	CompleteLogin(token, code string) (*User, error)
+
+	// Migration helpers
+	AutoMigrate() error
+	DestructiveReset() error
+}
+
+type twoFactorService struct {
+	us     UserService
+	db     *gorm.DB
+	hmac   hash.HMAC
+	tokens *hash.TokenCodec
+}
+
+// NewTwoFactorService returns a TwoFactorService INTERFACE that other
+// packages will use for two-factor authentication
+func NewTwoFactorService(db *gorm.DB, us UserService, keys Keys) TwoFactorService {
+	hmac := hash.NewKeyedHMAC(keys.HMAC)
+	return &twoFactorService{
+		us:     us,
+		db:     db,
+		hmac:   hmac,
+		tokens: hash.NewTokenCodec(hmac),
+	}
+}
+
+// BeginEnrollment saves a new, not yet enabled, TOTP secret
+func (tfs *twoFactorService) BeginEnrollment(user *User) error {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = secret
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	return tfs.us.Update(user)
+}
+
+// ProvisioningURI returns the otpauth:// URI for the user's secret
+func (tfs *twoFactorService) ProvisioningURI(user *User) string {
+	return totp.URI(totpIssuer, user.Email, user.TOTPSecret)
+}
+
+// ConfirmEnrollment enables two-factor authentication once the user
+// enters a valid code from their app
+func (tfs *twoFactorService) ConfirmEnrollment(user *User, code string) ([]string, error) {
+	// a code from the enabled secret would otherwise replace the
+	// recovery codes without the password being asked for
+	if user.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorCodeInvalid
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrTwoFactorCodeInvalid
+	}
+
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	if err := tfs.us.Update(user); err != nil {
+		return nil, err
+	}
+	return tfs.RegenerateRecoveryCodes(user)
+}
+
+// Disable turns off two-factor authentication for the user
+func (tfs *twoFactorService) Disable(user *User) error {
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	if err := tfs.us.Update(user); err != nil {
+		return err
+	}
+	return tfs.deleteRecoveryCodes(user.ID)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (tfs *twoFactorService) RegenerateRecoveryCodes(user *User) ([]string, error) {
+	if err := tfs.deleteRecoveryCodes(user.ID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b, err := rand.Bytes(recoveryCodeBytes)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		rc := recoveryCode{
+			UserID:   user.ID,
+			CodeHash: tfs.hashRecoveryCode(code),
+		}
+		if err := tfs.db.Create(&rc).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns how many unused recovery codes the user has
+func (tfs *twoFactorService) RecoveryCodesLeft(user *User) (int, error) {
+	var n int
+	err := tfs.db.Model(&recoveryCode{}).Where("user_id = ?", user.ID).Count(&n).Error
+	return n, err
+}
+
+// LoginToken returns a signed token naming the user, valid for
+// twoFactorLoginDuration
+func (tfs *twoFactorService) LoginToken(user *User) (string, error) {
+	if user.ID <= 0 {
+		return "", ErrIDInvalid
+	}
+	return tfs.tokens.Encode(hash.Claims{
+		Purpose: tokenPurposeTwoFactor,
+		Subject: user.ID,
+		Expires: time.Now().Add(twoFactorLoginDuration),
+	})
+}
+
+// LoginUser looks up the user the token was issued to, who must
+// still have two-factor authentication enabled
+func (tfs *twoFactorService) LoginUser(token string) (*User, error) {
+	claims, err := decodeToken(tfs.tokens, token, tokenPurposeTwoFactor)
+	if err != nil {
+		return nil, err
+	}
+	user, err := tfs.us.ByID(claims.Subject)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if !user.TwoFactorEnabled() {
+		return nil, ErrTokenInvalid
+	}
+	return user, nil
+}
+
+// CompleteLogin accepts either a current authenticator app code, which
+// may only be used once, or one of the user's unused recovery codes,
+// which is then consumed.
+func (tfs *twoFactorService) CompleteLogin(token, code string) (*User, error) {
+	user, err := tfs.LoginUser(token)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+	if ok {
+		if step <= user.TOTPLastStep {
+			// this code (or a later one) has already been used
+			return nil, ErrTwoFactorCodeInvalid
+		}
+		user.TOTPLastStep = step
+		if err := tfs.us.Update(user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	if err := tfs.useRecoveryCode(user, code); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// useRecoveryCode deletes the matching recovery code, or returns
+// ErrTwoFactorCodeInvalid if the user has no such code
+func (tfs *twoFactorService) useRecoveryCode(user *User, code string) error {
+	var rc recoveryCode
+	db := tfs.db.Where("user_id = ? AND code_hash IN (?)", user.ID, tfs.recoveryCodeHashes(code))
+	if err := first(db, &rc); err != nil {
+		if err == ErrNotFound {
+			return ErrTwoFactorCodeInvalid
+		}
+		return err
+	}
+	return tfs.db.Unscoped().Delete(&rc).Error
+}
+
+// deleteRecoveryCodes deletes all of the user's recovery codes
+func (tfs *twoFactorService) deleteRecoveryCodes(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+	return tfs.db.Unscoped().Where("user_id = ?", userID).Delete(&recoveryCode{}).Error
+}
+
+// hashRecoveryCode normalizes what the user typed (case, dashes and
+// spaces don't matter) and returns its HMAC
+func (tfs *twoFactorService) hashRecoveryCode(code string) string {
+	return tfs.hmac.Hash(normalizeRecoveryCode(code))
+}
+
+// recoveryCodeHashes returns the HMAC of the normalized code made
+// with each key, since the code may have been issued before the
+// current key was added
+func (tfs *twoFactorService) recoveryCodeHashes(code string) []string {
+	return tfs.hmac.Hashes(normalizeRecoveryCode(code))
+}
+
+// normalizeRecoveryCode removes the differences in case, dashes and
+// spaces that don't matter in a recovery code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// DestructiveReset drops the recovery codes table and rebuilds it
+func (tfs *twoFactorService) DestructiveReset() error {
+	err := tfs.db.DropTableIfExists(&recoveryCode{}).Error
+	if err != nil {
+		return err
+	}
+	return tfs.AutoMigrate()
+}
+
+// AutoMigrate will attempt to automaticaly migrate
+// the recovery codes table
+func (tfs *twoFactorService) AutoMigrate() error {
+	if err := tfs.db.AutoMigrate(&recoveryCode{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peterpla/webdevgo/hash"
+)
+
+func TestTwoFactorLoginTokenAndRecoveryCodes(t *testing.T) {
+	hmac := hash.NewHMAC(hmacSecretKey)
+	tfs := &twoFactorService{hmac: hmac, tokens: hash.NewTokenCodec(hmac)}
+
+	wrongPurpose, _ := tfs.tokens.Encode(hash.Claims{
+		Purpose: tokenPurposeVerify,
+		Subject: 42,
+		Expires: time.Now().Add(time.Hour),
+	})
+	expired, _ := tfs.tokens.Encode(hash.Claims{
+		Purpose: tokenPurposeTwoFactor,
+		Subject: 42,
+		Expires: time.Now().Add(-time.Minute),
+	})
+
+	// a login token for the wrong purpose, or expired, is rejected
+	// before the database is consulted
+	for _, bad := range []string{
+		"",
+		"no-dot-here",
+		wrongPurpose,
+		expired,
+	} {
+		if _, err := tfs.CompleteLogin(bad, "123456"); err != ErrTokenInvalid {
+			t.Errorf("tfs.CompleteLogin(%q): expected \"%v\", got \"%v\"", bad, ErrTokenInvalid, err)
+		}
+		if _, err := tfs.LoginUser(bad); err != ErrTokenInvalid {
+			t.Errorf("tfs.LoginUser(%q): expected \"%v\", got \"%v\"", bad, ErrTokenInvalid, err)
+		}
+	}
+
+	if _, err := tfs.LoginToken(&User{}); err != ErrIDInvalid {
+		t.Errorf("tfs.LoginToken(no ID): expected \"%v\", got \"%v\"", ErrIDInvalid, err)
+	}
+
+	// enrollment can't be confirmed again once enabled
+	now := time.Now()
+	enabled := &User{TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabledAt: &now}
+	if _, err := tfs.ConfirmEnrollment(enabled, "123456"); err != ErrTwoFactorEnabled {
+		t.Errorf("tfs.ConfirmEnrollment(enabled): expected \"%v\", got \"%v\"", ErrTwoFactorEnabled, err)
+	}
+
+	// recovery codes may be typed in any case, with or without the dash
+	want := tfs.hashRecoveryCode("abcd-ef23")
+	for _, typed := range []string{"abcdef23", "ABCD-EF23", " abcd ef23 "} {
+		if got := tfs.hashRecoveryCode(typed); got != want {
+			t.Errorf("tfs.hashRecoveryCode(%q): expected it to match \"abcd-ef23\"", typed)
+		}
+	}
+}
@@ -1,16 +1,18 @@
 package models
 
 import (
+	"fmt"
 	"log"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres" // load gorm's postgres driver
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/peterpla/webdevgo/hash"
-	"github.com/peterpla/webdevgo/rand"
+	"github.com/peterpla/webdevgo/password"
 )
 
 // User ... [TODO: add documentation]
@@ -20,8 +22,29 @@ type User struct {
 	Email        string `gorm:"not null;unique_index"`
 	Password     string `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
-	Remember     string `gorm:"-"`
-	RememberHash string `gorm:"not null;unique_index"`
+
+	// EmailVerifiedAt is when the user followed the verification
+	// link we emailed them, or nil if they have not yet
+	EmailVerifiedAt *time.Time
+
+	// TOTPSecret is the user's authenticator app secret. It is set when
+	// two-factor enrollment begins, but only used once TOTPEnabledAt is.
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the time step of the last code accepted, so a
+	// code can't be replayed
+	TOTPLastStep int64
+
+	// Admin users can see the administration pages. It is only ever
+	// granted directly in the database, never through a form.
+	Admin bool `gorm:"not null;default:false"`
+}
+
+// NormalizeEmail returns the email address as it is stored: lower
+// case, without surrounding whitespace. Use it to compare a submitted
+// address with a user's.
+func NormalizeEmail(email string) string {
+	return strings.TrimSpace(strings.ToLower(email))
 }
 
 // userValidator is our validation/normalization layer that
@@ -29,8 +52,9 @@ type User struct {
 // interface chain
 type userValidator struct {
 	UserDB
-	hmac       hash.HMAC
 	emailRegex *regexp.Regexp
+	pw         *password.Hasher
+	policy     password.Policy
 }
 
 // a compile-time error below indicates the UserDB type no longer matches
@@ -52,7 +76,6 @@ type UserDB interface {
 	// Methods for querying for a single user
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
 
 	// Methods for altering a single user
 	Create(user *User) error
@@ -90,6 +113,19 @@ var (
 	// shorter than 8 characters
 	ErrPasswordTooShort modelError = "models: password must be at least 8 characters long"
 
+	// ErrPasswordBreached is returned when a password has appeared
+	// in a data breach, or is one of the most common passwords
+	ErrPasswordBreached modelError = "models: password is known to attackers from a data breach, please choose another"
+
+	// ErrPasswordPersonal is returned when a password contains the
+	// user's email address or name
+	ErrPasswordPersonal modelError = "models: password must not contain your name or email address"
+
+	// ErrPasswordTooWeak is wrapped by the PasswordTooWeakError
+	// returned when a password's strength score is below the
+	// password policy's minimum
+	ErrPasswordTooWeak modelError = "models: password is too easy to guess, try a longer one or a few unrelated words"
+
 	// ErrPasswordIncorrect is returned when an invalid password
 	// is dtected when attempting to authenticate a user.
 	ErrPasswordIncorrect modelError = "models: incorrect password provided"
@@ -106,13 +142,17 @@ var (
 	// specifying an email address that is already in use (found in the database)
 	ErrEmailTaken modelError = "models: email address is already taken"
 
-	// ErrRememberRequired is returned when a create or update
-	// is attempted without a user Remember token hash
+	// ErrRememberRequired is returned when a session create or update
+	// is attempted without a Remember token hash
 	ErrRememberRequired modelError = "models: remember token is required"
 
 	// ErrRememberTooShort is returned when a Remember token
 	// is not at least 32 bytes
 	ErrRememberTooShort modelError = "models: remember token must be at least 32 bytes"
+
+	// ErrTokenInvalid is returned when a password reset or email
+	// verification token is missing, unknown, already used or expired
+	ErrTokenInvalid modelError = "models: token provided is not valid"
 )
 
 // userGorm represents our database interaction layer
@@ -133,37 +173,84 @@ type UserService interface {
 	// Otherwise, return ErrNotFound, ErrPasswordIncorrect, or
 	// pass along an error received from deeper in the stack.
 	Authenticate(email string, password string) (*User, error)
+
+	// ByRemember looks up the session identified by the provided
+	// remember token, and returns the user who owns it.
+	// If no session matches the token, return ErrNotFound.
+	ByRemember(token string) (*User, error)
+
+	// InitiateReset starts the password reset process for the user
+	// with the provided email address, returning the user and the
+	// reset token to send to them.
+	InitiateReset(email string) (*User, string, error)
+
+	// CompleteReset sets a new password for the user who owns the
+	// provided reset token, and signs them out of every device.
+	// An unknown, used or expired token results in ErrTokenInvalid.
+	CompleteReset(token, newPw string) (*User, error)
+
+	// EmailVerifyToken returns a signed, expiring token for the
+	// user's email verification link.
+	EmailVerifyToken(user *User) (string, error)
+
+	// VerifyEmail marks the email address named in the token as
+	// verified. An invalid or expired token results in ErrTokenInvalid.
+	VerifyEmail(token string) (*User, error)
 	UserDB
 }
 
 type userService struct {
 	UserDB
+	sessions SessionService
+	pwResets pwResetDB
+	tokens   *hash.TokenCodec
+	pw       *password.Hasher
 }
 
-const hmacSecretKey = "secret-hmac-key"
-const userPwPepper = "secret-random-string"
+// bcryptCost is the cost used for bcrypt password hashes. New hashes
+// are made with Argon2id; bcrypt is kept to verify existing ones.
+const bcryptCost = bcrypt.DefaultCost
+
+// newPasswordHasher returns the hasher for user passwords. Hashes made
+// by any scheme other than the first, with other parameters, or with
+// an older pepper, are upgraded the next time the user logs in.
+func newPasswordHasher(peppers *hash.Keyring) *password.Hasher {
+	return password.NewHasher(peppers,
+		password.DefaultArgon2id,
+		password.Bcrypt{Cost: bcryptCost})
+}
 
 // NewUserService returns a UserService INTERFACE that other
-// packages will use to access the user database.
-func NewUserService(db *gorm.DB) UserService {
+// packages will use to access the user database. Remember tokens
+// are resolved through the provided SessionService, and new
+// passwords must satisfy policy.
+func NewUserService(db *gorm.DB, ss SessionService, keys Keys, policy password.Policy) UserService {
 	ug := &userGorm{db}
 	log.Printf("enter NewUserService, ug: %+v", ug)
 
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
+	pw := newPasswordHasher(keys.Pepper)
+	uv := newUserValidator(ug, pw, policy)
+
+	hmac := hash.NewKeyedHMAC(keys.HMAC)
+	pwrv := newPwResetValidator(&pwResetGorm{db}, hmac)
 
 	u := &userService{
-		UserDB: uv,
+		UserDB:   uv,
+		sessions: ss,
+		pwResets: pwrv,
+		tokens:   hash.NewTokenCodec(hmac),
+		pw:       pw,
 	}
 	return u
 }
 
 // newUserValidator returns a pointer to a userValidator instance
-func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
+func newUserValidator(udb UserDB, pw *password.Hasher, policy password.Policy) *userValidator {
 	return &userValidator{
 		UserDB:     udb,
-		hmac:       hmac,
 		emailRegex: regexp.MustCompile(`[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
+		pw:         pw,
+		policy:     policy,
 	}
 }
 
@@ -180,25 +267,131 @@ func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
 //   user, nil
 // If there is another error, return
 //   nil, error
-func (us *userService) Authenticate(email string, password string) (*User, error) {
+// After a successful login, a PasswordHash made by an older algorithm
+// or with outdated parameters is replaced with a current one.
+func (us *userService) Authenticate(email string, pw string) (*User, error) {
 	foundUser, err := us.ByEmail(email)
 	if err != nil {
 		return nil, err // pass on ByEmail's error return, email not found in the database
 	}
 
 	// test the provided password against the stored PasswordHash
-	err = bcrypt.CompareHashAndPassword(
-		[]byte(foundUser.PasswordHash),
-		[]byte(password+userPwPepper))
+	rehash, err := us.pw.Verify(foundUser.PasswordHash, pw)
 
 	switch err {
 	case nil:
-		return foundUser, nil // SUCCESS, return user populated with fields from DB
-	case bcrypt.ErrMismatchedHashAndPassword:
+		// SUCCESS, return user populated with fields from DB
+	case password.ErrMismatch:
 		return nil, ErrPasswordIncorrect // password did not produce matching hash
 	default:
 		return nil, err // some other error
 	}
+
+	if rehash {
+		if err := us.rehashPassword(foundUser, pw); err != nil {
+			// the old hash still works, so don't fail the login
+			log.Printf("rehashing password for user %d: %v", foundUser.ID, err)
+		}
+	}
+	return foundUser, nil
+}
+
+// rehashPassword replaces the user's PasswordHash with one made by the
+// current scheme. The hash is set directly, rather than through
+// user.Password, so the password isn't re-checked against rules that
+// may have changed since it was chosen.
+func (us *userService) rehashPassword(user *User, pw string) error {
+	hashed, err := us.pw.Hash(pw)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hashed
+	return us.Update(user)
+}
+
+// ByRemember finds the session for the provided remember token and
+// returns its user, recording that the session was just used.
+func (us *userService) ByRemember(token string) (*User, error) {
+	session, err := us.sessions.ByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.ByID(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.sessions.Touch(session); err != nil {
+		// not worth failing the request over
+		log.Printf("touching session %d: %v", session.ID, err)
+	}
+	return user, nil
+}
+
+// Delete deletes the user, then signs them out of every device
+func (us *userService) Delete(id uint) error {
+	if err := us.UserDB.Delete(id); err != nil {
+		return err
+	}
+	return us.sessions.DeleteByUserID(id)
+}
+
+// InitiateReset looks up the user by email address and creates a
+// password reset for them, returning the user along with the
+// single-use reset token. An unknown email address results in
+// ErrNotFound.
+func (us *userService) InitiateReset(email string) (*User, string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pwr := pwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResets.Create(&pwr); err != nil {
+		return nil, "", err
+	}
+	return user, pwr.Token, nil
+}
+
+// CompleteReset validates the reset token, updates the user's password
+// through the usual validation chain (passwordMinLength, hashPassword,
+// ...), then consumes the token and deletes all of the user's sessions
+// so any remember token issued before the reset stops working.
+func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	pwr, err := us.pwResets.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if time.Now().After(pwr.ExpiresAt) {
+		us.pwResets.Delete(pwr.ID)
+		return nil, ErrTokenInvalid
+	}
+
+	user, err := us.ByID(pwr.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update only re-hashes a password when one is provided
+	if newPw == "" {
+		return nil, ErrPasswordRequired
+	}
+	user.Password = newPw
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+
+	if err := us.pwResets.Delete(pwr.ID); err != nil {
+		return nil, err
+	}
+	if err := us.sessions.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 /* ********** ********** ********** */
@@ -249,20 +442,6 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	return &user, err
 }
 
-// ByRemember looks up a user by remember token hash and returns
-// that user.
-// Errors are the same as ByEmail above
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
-	// fmt.Printf("enter ByRemember, rememberHash=%s\n", rememberHash)
-	var user User
-	err := first(ug.db.Where("remember_hash = ?", rememberHash), &user)
-	if err != nil {
-		return nil, err
-	}
-	// fmt.Printf("exit ByRemember, user:%+v\n", user)
-	return &user, nil
-}
-
 // Update expects the Name, Email and Password fields to be
 // validated and normalized, and will update the user's DB record with the
 // provided User object
@@ -286,9 +465,9 @@ func (ug *userGorm) Close() error {
 	return ug.db.Close()
 }
 
-// DestructiveReset drops the user table and rebuilds it
+// DestructiveReset drops the user and password reset tables and rebuilds them
 func (ug *userGorm) DestructiveReset() error {
-	err := ug.db.DropTableIfExists(&User{}).Error
+	err := ug.db.DropTableIfExists(&User{}, &pwReset{}).Error
 	if err != nil {
 		return err
 	}
@@ -296,11 +475,18 @@ func (ug *userGorm) DestructiveReset() error {
 }
 
 // AutoMigrate will attempt to automaticaly migrate
-// the Users table
+// the Users and password resets tables
 func (ug *userGorm) AutoMigrate() error {
-	if err := ug.db.AutoMigrate(&User{}).Error; err != nil {
+	if err := ug.db.AutoMigrate(&User{}, &pwReset{}).Error; err != nil {
 		return err
 	}
+	// remember tokens moved to the sessions table; the old NOT NULL
+	// column would otherwise reject every new user
+	if ug.db.Dialect().HasColumn("users", "remember_hash") {
+		if err := ug.db.Model(&User{}).DropColumn("remember_hash").Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -325,8 +511,8 @@ func (e modelError) Public() string {
 /*       userValidator methods      */
 
 // Create will validate arguments, create the password hash, overwrite the password
-// value with an empty string, set the remember token and hash; then pass to the
-// database layer to create the user record in the database
+// value with an empty string; then pass to the database layer to create the user
+// record in the database
 func (uv *userValidator) Create(user *User) error {
 	/*
 		if user.Password == "" {
@@ -336,12 +522,9 @@ func (uv *userValidator) Create(user *User) error {
 	err := runUserValFns(user,
 		uv.passwordRequired,     // 1 - sequence matters!
 		uv.passwordMinLength,    // 2 - sequence matters!
-		uv.bcryptPassword,       // 3 - sequence matters!
-		uv.passwordHashRequired, // 4 - sequence matters!
-		uv.setRememberIfUnset,
-		uv.rememberMinBytes, // after setRemember - sequence matters!
-		uv.hmacRemember,
-		uv.rememberHashRequired, // after hmacRemember - sequence matters!
+		uv.passwordPolicy,       // 3 - sequence matters!
+		uv.hashPassword,         // 4 - sequence matters!
+		uv.passwordHashRequired, // 5 - sequence matters!
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
@@ -352,16 +535,14 @@ func (uv *userValidator) Create(user *User) error {
 	return uv.UserDB.Create(user)
 }
 
-// Update will set (normalize) the remember hash, then pass to the database layer to
+// Update will validate and normalize the user, then pass to the database layer to
 // update the user record in the database.
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValFns(user,
 		uv.passwordMinLength,    // 1 - sequence matters!
-		uv.bcryptPassword,       // 2 - sequence matters!
-		uv.passwordHashRequired, // 3 - sequence matters!
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired, // after hmacRemember - sequence matters!
+		uv.passwordPolicy,       // 2 - sequence matters!
+		uv.hashPassword,         // 3 - sequence matters!
+		uv.passwordHashRequired, // 4 - sequence matters!
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
@@ -398,40 +579,6 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
-// ByRemember normalization: hash the remember token and then pass it
-// to UserDB's ByRemember
-func (uv *userValidator) ByRemember(token string) (*User, error) {
-	user := User{
-		Remember: token,
-	}
-	if err := runUserValFns(&user, uv.hmacRemember); err != nil {
-		return nil, err
-	}
-	return uv.UserDB.ByRemember(user.RememberHash)
-}
-
-// hmacRemember calculates and stores in User the remember token hash
-func (uv *userValidator) hmacRemember(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return nil
-}
-
-// setRememberIfUnset ensures User has a remember token
-func (uv *userValidator) setRememberIfUnset(user *User) error {
-	if user.Remember != "" {
-		return nil
-	}
-	token, err := rand.RememberToken()
-	if err != nil {
-		return err
-	}
-	user.Remember = token
-	return nil
-}
-
 // idGreaterThan ensures the ID is greater than the provided argument
 func (uv *userValidator) idGreaterThan(n uint) userValFn {
 	return userValFn(func(user *User) error {
@@ -444,8 +591,7 @@ func (uv *userValidator) idGreaterThan(n uint) userValFn {
 
 // normalize email address by converting to lower case and trimming whitespace
 func (uv *userValidator) normalizeEmail(user *User) error {
-	user.Email = strings.ToLower(user.Email)
-	user.Email = strings.TrimSpace(user.Email)
+	user.Email = NormalizeEmail(user.Email)
 	return nil
 }
 
@@ -501,45 +647,66 @@ func (uv *userValidator) passwordMinLength(user *User) error {
 	return nil
 }
 
-// ensure password is not empty
-func (uv *userValidator) passwordRequired(user *User) error {
-	if user.Password == "" {
-		return ErrPasswordRequired
-	}
-	return nil
+// PasswordTooWeakError is returned when a password's strength score
+// is below the password policy's minimum. It tells the user how
+// strong their password was, and how strong it needs to be.
+type PasswordTooWeakError struct {
+	Score    int // from password.Score
+	MinScore int
 }
 
-// ensure password hash is not empty
-func (uv *userValidator) passwordHashRequired(user *User) error {
-	if user.PasswordHash == "" {
-		return ErrPasswordRequired
-	}
-	return nil
+func (e PasswordTooWeakError) Error() string {
+	return fmt.Sprintf("models: password strength is %s but must be at least %s, try a longer one or a few unrelated words",
+		password.ScoreName(e.Score), password.ScoreName(e.MinScore))
+}
+
+// Public returns the error string, as for a modelError
+func (e PasswordTooWeakError) Public() string {
+	return modelError(e.Error()).Public()
 }
 
-// ensure Remember hash is >= 32 bytes
-func (uv *userValidator) rememberMinBytes(user *User) error {
-	if user.Remember == "" {
-		// Remember tokens arent' always updated, so see if one is provided.
-		// If not, trust other validations will catch any errors.
+// Unwrap returns ErrPasswordTooWeak
+func (e PasswordTooWeakError) Unwrap() error {
+	return ErrPasswordTooWeak
+}
+
+// ensure a new password satisfies the password policy: not breached
+// or common, free of the user's email address and name, and strong
+// enough
+func (uv *userValidator) passwordPolicy(user *User) error {
+	if user.Password == "" { // password required handled elsewhere
 		return nil
 	}
-	n, err := rand.NBytes(user.Remember)
-	if err != nil {
-		return err
+	res, err := uv.policy.Check(user.Password, user.Email, user.Name)
+	switch err {
+	case nil:
+		return nil
+	case password.ErrBreached:
+		return ErrPasswordBreached
+	case password.ErrPersonal:
+		return ErrPasswordPersonal
+	case password.ErrTooWeak:
+		return PasswordTooWeakError{Score: res.Score, MinScore: uv.policy.MinScore}
+	default:
+		// the breach corpus couldn't be read; that isn't the
+		// user's fault, so don't refuse their password over it
+		log.Printf("checking password policy: %v", err)
+		return nil
 	}
+}
 
-	if n < 32 { // CAUTION: hard-coded constant, unlikely to change
-		return ErrRememberTooShort
+// ensure password is not empty
+func (uv *userValidator) passwordRequired(user *User) error {
+	if user.Password == "" {
+		return ErrPasswordRequired
 	}
-
 	return nil
 }
 
-// ensure Remember hash is provided
-func (uv *userValidator) rememberHashRequired(user *User) error {
-	if user.RememberHash == "" {
-		return ErrRememberRequired
+// ensure password hash is not empty
+func (uv *userValidator) passwordHashRequired(user *User) error {
+	if user.PasswordHash == "" {
+		return ErrPasswordRequired
 	}
 	return nil
 }
@@ -561,22 +728,21 @@ func runUserValFns(user *User, fns ...userValFn) error {
 	return nil
 }
 
-// bcryptPassword will hash a user's password with an
-// app-wide pepper and becrypt, which salts for us
-func (uv *userValidator) bcryptPassword(user *User) error {
+// hashPassword will hash a user's password with the current
+// password scheme, if the password is provided
+func (uv *userValidator) hashPassword(user *User) error {
 	if user.Password == "" {
 		// Nothing to do if a new password wasn't provided.
 		return nil
 	}
 
-	pwBytes := []byte(user.Password + userPwPepper)
-	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	hashed, err := uv.pw.Hash(user.Password)
 	if err != nil {
 		return err
 	}
 
-	user.PasswordHash = string(hashedBytes) // save the PasswordHash in the user object
-	user.Password = ""                      // ... but overwrite the Password immediately (does not reach DB)
+	user.PasswordHash = hashed // save the PasswordHash in the user object
+	user.Password = ""         // ... but overwrite the Password immediately (does not reach DB)
 	return nil
 }
 
@@ -8,6 +8,11 @@ import (
 	"strconv"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/peterpla/webdevgo/password"
+	"github.com/peterpla/webdevgo/storage"
 )
 
 // "github.com/peterpla/webdevgo/controllers"
@@ -26,13 +31,15 @@ func TestMain(m *testing.M) {
 	connStr = fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable", dbHost, dbPort, dbUser, dbName)
 	// fmt.Printf("TestMain: %s\n", connStr)
 	// initialize services and database connection
-	services, err := NewServices(connStr)
+	var err error
+	services, err = NewServices(connStr, DefaultKeys(), password.DefaultPolicy(), &storage.Memory{})
 	if err != nil {
 		panic(err)
 	}
-	defer services.User.Close()
 
-	os.Exit(m.Run())
+	code := m.Run()
+	services.User.Close()
+	os.Exit(code)
 }
 
 func TestNewUserServiceByIDAndClose(t *testing.T) {
@@ -54,7 +61,7 @@ func TestCreateByEmailAndDelete(t *testing.T) {
 
 	name := fmt.Sprintf("Test%s User", r)
 	email := fmt.Sprintf("test%s@test.com", r)
-	pwd := fmt.Sprintf("test%sPASS", r)
+	pwd := fmt.Sprintf("secret%sPASS", r)
 
 	// Create a test user
 	user := User{
@@ -118,138 +125,108 @@ func TestCreateByEmailAndDelete(t *testing.T) {
 
 }
 
-/*
-func TestUvHmacRemember(t *testing.T) {
-	// connect to the user database
-	ug, err := newUserGorm(connStr)
+func TestPasswordReset(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	user := User{
+		Name:     "Reset Test",
+		Email:    fmt.Sprintf("reset-test%d@test.com", rand.Intn(math.MaxUint16)),
+		Password: "originalPASS",
+	}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatalf("us.Create(): expected nil, got \"%v\"", err)
+	}
+	defer services.User.Delete(user.ID)
+
+	session := Session{UserID: user.ID}
+	if err := services.Session.Create(&session); err != nil {
+		t.Fatalf("ss.Create(): expected nil, got \"%v\"", err)
+	}
+
+	resetUser, token, err := services.User.InitiateReset(user.Email)
 	if err != nil {
-		t.Error("could not connect to database")
-	}
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
-
-	type testset struct {
-		testUser     User
-		expErr       error
-		expHashEmpty bool
-	}
-
-	var tests = []testset{
-		{
-			User{
-				Name:         "Bozo Clown",
-				Email:        "bozo@clown.net",
-				Password:     "",
-				PasswordHash: "",
-				Remember:     "",
-				RememberHash: "", // should be untouched
-			},
-			nil,
-			true,
-		},
-		{
-			User{
-				Name:         "Bozo Clown",
-				Email:        "bozo@clown.net",
-				Password:     "",
-				PasswordHash: "",
-				Remember:     "notempty",
-				RememberHash: "", // should get new hash
-			},
-			nil,
-			false,
-		},
-	}
-
-	for _, r := range tests {
-		// log.Printf("*** current test: User: %+v, expErr: %+v, expHashEmpty: %t\n",
-		// 	r.testUser, r.expErr, r.expHashEmpty)
-
-		err := uv.hmacRemember(&r.testUser)
-
-		if err != r.expErr {
-			t.Errorf("hmacRemember: got %v, want %v", err, r.expErr)
-		}
-
-		if r.expHashEmpty && r.testUser.RememberHash != "" {
-			// expect Remember hash to be "", but it isn't
-			t.Errorf("hmacRemember: got %s, want %s", r.testUser.RememberHash, "")
-		}
-		if !r.expHashEmpty && r.testUser.RememberHash == "" {
-			// expect Remember hash to be not-empty, but it is
-			t.Errorf("hmacRemember: got %s, want %s", r.testUser.RememberHash, "")
-		}
+		t.Fatalf("us.InitiateReset(): expected nil, got \"%v\"", err)
+	}
+	if resetUser.ID != user.ID {
+		t.Errorf("us.InitiateReset(): expected user %d, got %d", user.ID, resetUser.ID)
+	}
+
+	if _, err := services.User.CompleteReset(token, "short"); err != ErrPasswordTooShort {
+		t.Errorf("us.CompleteReset(): expected \"%v\", got \"%v\"", ErrPasswordTooShort, err)
+	}
+	if _, err := services.User.CompleteReset(token, "replacementPASS"); err != nil {
+		t.Fatalf("us.CompleteReset(): expected nil, got \"%v\"", err)
+	}
+
+	// the token is single-use
+	if _, err := services.User.CompleteReset(token, "anotherPASS"); err != ErrTokenInvalid {
+		t.Errorf("us.CompleteReset(): expected \"%v\", got \"%v\"", ErrTokenInvalid, err)
+	}
+
+	// existing remember tokens no longer work
+	if _, err := services.User.ByRemember(session.Token); err != ErrNotFound {
+		t.Errorf("us.ByRemember(): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+
+	if _, err := services.User.Authenticate(user.Email, "originalPASS"); err != ErrPasswordIncorrect {
+		t.Errorf("us.Authenticate(old): expected \"%v\", got \"%v\"", ErrPasswordIncorrect, err)
+	}
+	if _, err := services.User.Authenticate(user.Email, "replacementPASS"); err != nil {
+		t.Errorf("us.Authenticate(new): expected nil, got \"%v\"", err)
 	}
 }
-*/
 
-/*
-func TestSetRememberIfUnset(t *testing.T) {
-	// connect to the user database
-	ug, err := newUserGorm(connStr)
+func TestAuthenticateRehashesLegacyPassword(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	user := User{
+		Name:     "Rehash Test",
+		Email:    fmt.Sprintf("rehash-test%d@test.com", rand.Intn(math.MaxUint16)),
+		Password: "originalPASS",
+	}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatalf("us.Create(): expected nil, got \"%v\"", err)
+	}
+	defer services.User.Delete(user.ID)
+
+	// replace the hash with one made the way passwords used to be
+	legacy, err := password.NewHasher(DefaultKeys().Pepper, password.Bcrypt{Cost: bcrypt.MinCost}).Hash("originalPASS")
 	if err != nil {
-		t.Error("could not connect to database")
-	}
-
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
-
-	type testset struct {
-		testUser                User
-		expErr                  error
-		expRememberShouldChange bool
-	}
-
-	var tests = []testset{
-		{
-			User{
-				Name:         "Bozo Clown",
-				Email:        "bozo@clown.net",
-				Password:     "",
-				PasswordHash: "",
-				Remember:     "", // blank, should change
-				RememberHash: "",
-			},
-			nil,
-			true,
-		},
-		// TODO: confirm this test fails due to controllers/user.go/SignIn also sets Remember?
-		{
-			User{
-				Name:         "Bozo Clown",
-				Email:        "bozo@clown.net",
-				Password:     "",
-				PasswordHash: "",
-				Remember:     "notempty", // non-empty, should NOT change
-				RememberHash: "",
-			},
-			nil,
-			false,
-		},
-	}
-
-	for _, r := range tests {
-		// log.Printf("*** current test: User: %+v, expErr: %+v, expHashEmpty: %t\n",
-		// 	r.testUser, r.expErr, r.expHashEmpty)
-
-		origRemember := r.testUser.Remember
-		err = uv.setRememberIfUnset(&r.testUser)
-
-		if err != r.expErr {
-			t.Errorf("setRememberIfUnset: got %v, want %v", err, r.expErr)
-		}
-
-		if r.expRememberShouldChange && r.testUser.Remember == origRemember {
-			// expect Remember to change, but it didn't
-			t.Errorf("setRememberIfUnset didn't change: got %q, want %q",
-				r.testUser.Remember, origRemember)
-		}
-		if !r.expRememberShouldChange && r.testUser.RememberHash != origRemember {
-			// expect Remember to be unchanged, but it changed
-			t.Skip("setRememberIfUnset when should not change, still does - outside forces?")
-			t.Errorf("setRememberIfUnset changed: got %q, want %q",
-				r.testUser.RememberHash, origRemember)
-		}
+		t.Fatalf("Hash(): expected nil, got \"%v\"", err)
+	}
+	user.PasswordHash = legacy
+	if err := services.User.Update(&user); err != nil {
+		t.Fatalf("us.Update(): expected nil, got \"%v\"", err)
+	}
+
+	if _, err := services.User.Authenticate(user.Email, "originalPASS"); err != nil {
+		t.Fatalf("us.Authenticate(): expected nil, got \"%v\"", err)
+	}
+
+	found, err := services.User.ByID(user.ID)
+	if err != nil {
+		t.Fatalf("us.ByID(): expected nil, got \"%v\"", err)
+	}
+	if !password.DefaultArgon2id.Handles(found.PasswordHash) {
+		t.Errorf("PasswordHash after login: expected argon2id, got %q", found.PasswordHash)
+	}
+	if _, err := services.User.Authenticate(user.Email, "originalPASS"); err != nil {
+		t.Errorf("us.Authenticate(after rehash): expected nil, got \"%v\"", err)
+	}
+}
+
+func TestPasswordPolicyTooWeak(t *testing.T) {
+	uv := &userValidator{policy: password.DefaultPolicy()}
+
+	err := uv.passwordPolicy(&User{Email: "bozo@clown.net", Password: "zqxjkvb"})
+	weak, ok := err.(PasswordTooWeakError)
+	if !ok {
+		t.Fatalf("uv.passwordPolicy(): expected PasswordTooWeakError, got \"%v\"", err)
+	}
+	if weak.Score != password.ScoreWeak || weak.MinScore != password.ScoreFair {
+		t.Errorf("uv.passwordPolicy(): expected scores %d and %d, got %d and %d",
+			password.ScoreWeak, password.ScoreFair, weak.Score, weak.MinScore)
+	}
+	want := "Password strength is weak but must be at least fair, try a longer one or a few unrelated words"
+	if got := weak.Public(); got != want {
+		t.Errorf("Public(): expected %q, got %q", want, got)
 	}
 }
-*/
@@ -0,0 +1,116 @@
+package password
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// argon2idPrefix tags hashes made by Argon2id. Hashes use the PHC
+// string format:
+//
+//	$argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+const argon2idPrefix = "$argon2id$"
+
+// errMalformed is returned for hashes that claim to be Argon2id
+// but cannot be parsed
+var errMalformed = errors.New("password: malformed argon2id hash")
+
+// Argon2id hashes passwords with Argon2id. Memory is in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2id uses the parameters recommended by RFC 9106 for
+// memory-constrained environments
+var DefaultArgon2id = Argon2id{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// b64 is the unpadded base64 used by the PHC string format
+var b64 = base64.RawStdEncoding
+
+// Hash returns the Argon2id hash of password with a new random salt
+func (a Argon2id) Hash(password []byte) (string, error) {
+	salt, err := rand.Bytes(int(a.SaltLen))
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(password, salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix,
+		argon2.Version, a.Memory, a.Time, a.Threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify recomputes the key with the hash's own salt and parameters
+// and compares it in constant time
+func (a Argon2id) Verify(hash string, password []byte) error {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	got := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// Handles reports whether hash is an Argon2id hash
+func (a Argon2id) Handles(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+// Outdated reports whether hash was made with different parameters
+func (a Argon2id) Outdated(hash string) bool {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Time != a.Time || params.Memory != a.Memory ||
+		params.Threads != a.Threads ||
+		uint32(len(salt)) != a.SaltLen || uint32(len(key)) != a.KeyLen
+}
+
+// parseArgon2id splits a PHC-format Argon2id hash into its
+// parameters, salt and key
+func parseArgon2id(hash string) (params Argon2id, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errMalformed
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errMalformed
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, errMalformed
+	}
+
+	if salt, err = b64.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errMalformed
+	}
+	if key, err = b64.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errMalformed
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
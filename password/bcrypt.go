@@ -0,0 +1,48 @@
+package password
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt hashes passwords with bcrypt at the given cost. Its hashes
+// use bcrypt's own "$2a$" style prefix, so hashes made before this
+// package existed are handled too.
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of password
+func (b Bcrypt) Hash(password []byte) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword(password, b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Verify compares password against a bcrypt hash
+func (b Bcrypt) Verify(hash string, password []byte) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	return err
+}
+
+// Handles reports whether hash is a bcrypt hash
+func (b Bcrypt) Handles(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Outdated reports whether hash was made at a different cost
+func (b Bcrypt) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
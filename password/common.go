@@ -0,0 +1,118 @@
+package password
+
+// commonPasswords are some of the most frequently used passwords of
+// at least 8 characters, from public password lists. Keep them lower
+// case.
+var commonPasswords = map[string]bool{
+	"00000000":      true,
+	"0123456789":    true,
+	"11111111":      true,
+	"11223344":      true,
+	"12341234":      true,
+	"12344321":      true,
+	"12345678":      true,
+	"123456789":     true,
+	"1234567890":    true,
+	"1234qwer":      true,
+	"1q2w3e4r":      true,
+	"1q2w3e4r5t":    true,
+	"1qaz2wsx":      true,
+	"87654321":      true,
+	"a1b2c3d4":      true,
+	"aa123456":      true,
+	"abc12345":      true,
+	"abcd1234":      true,
+	"abcdefgh":      true,
+	"access14":      true,
+	"admin123":      true,
+	"admin1234":     true,
+	"administrator": true,
+	"arsenal1":      true,
+	"asdf1234":      true,
+	"asdfghjk":      true,
+	"asdfghjkl":     true,
+	"autumn2020":    true,
+	"babygirl1":     true,
+	"baseball":      true,
+	"basketball":    true,
+	"batman123":     true,
+	"biteme12":      true,
+	"butterfly":     true,
+	"changeme":      true,
+	"changeme1":     true,
+	"charlie1":      true,
+	"chelsea1":      true,
+	"chocolate":     true,
+	"computer":      true,
+	"december":      true,
+	"default1":      true,
+	"dragon123":     true,
+	"facebook":      true,
+	"football":      true,
+	"football1":     true,
+	"football123":   true,
+	"freedom1":      true,
+	"google123":     true,
+	"hello123":      true,
+	"iloveu123":     true,
+	"iloveyou":      true,
+	"iloveyou1":     true,
+	"internet":      true,
+	"january1":      true,
+	"jennifer":      true,
+	"jordan23":      true,
+	"letmein1":      true,
+	"letmein123":    true,
+	"liverpool":     true,
+	"lovely123":     true,
+	"loveme123":     true,
+	"master123":     true,
+	"michael1":      true,
+	"michelle":      true,
+	"minecraft":     true,
+	"monkey123":     true,
+	"mustang1":      true,
+	"myspace1":      true,
+	"p@ssw0rd":      true,
+	"p@ssword":      true,
+	"passpass":      true,
+	"passw0rd":      true,
+	"password":      true,
+	"password!":     true,
+	"password1":     true,
+	"password12":    true,
+	"password123":   true,
+	"password1234":  true,
+	"pokemon1":      true,
+	"princess":      true,
+	"princess1":     true,
+	"q1w2e3r4":      true,
+	"q1w2e3r4t5":    true,
+	"qazwsxedc":     true,
+	"qwer1234":      true,
+	"qwerty12":      true,
+	"qwerty123":     true,
+	"qwerty1234":    true,
+	"qwertyui":      true,
+	"qwertyuiop":    true,
+	"root1234":      true,
+	"samsung1":      true,
+	"secret123":     true,
+	"shadow123":     true,
+	"spring2020":    true,
+	"starwars":      true,
+	"summer2019":    true,
+	"summer2020":    true,
+	"sunshine":      true,
+	"sunshine1":     true,
+	"superman":      true,
+	"trustno1":      true,
+	"welcome1":      true,
+	"welcome123":    true,
+	"whatever":      true,
+	"whatever1":     true,
+	"winter2020":    true,
+	"zaq12wsx":      true,
+	"zxcvbnm1":      true,
+	"zxcvbnm123":    true,
+}
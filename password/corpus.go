@@ -0,0 +1,84 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rangePrefixLen is the number of hex digits of the SHA-1 hash used
+// to name each range file
+const rangePrefixLen = 5
+
+// Corpus reports how many times a password is known to have
+// appeared in breaches. Zero means it hasn't been seen.
+type Corpus interface {
+	Count(password string) (int, error)
+}
+
+// RangeDir is a Corpus read from a directory of range files, one per
+// 5 hex digit SHA-1 prefix, in the format served by the Pwned
+// Passwords range API:
+//
+//	<dir>/5BAA6.txt:
+//	1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365
+//	...
+//
+// Each line is the rest of a password's SHA-1 hash and the number of
+// times it was seen. Only the file for the password's prefix is read,
+// so the directory can be refreshed (e.g. with the official
+// downloader) while the app is running, and no password, nor its full
+// hash, ever leaves the server. A missing range file counts as no
+// breaches.
+type RangeDir string
+
+// Count looks up the password's SHA-1 hash in its range file
+func (d RangeDir) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hexSum[:rangePrefixLen], hexSum[rangePrefixLen:]
+
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, ":")
+		if i < 0 || !strings.EqualFold(line[:i], suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return 0, err
+		}
+		return count, nil
+	}
+	return 0, scanner.Err()
+}
+
+// commonCorpus is the Corpus of the bundled common passwords
+type commonCorpus struct{}
+
+// Common is a Corpus of the most common passwords, bundled so that at
+// least these are refused when no breach corpus is available. Matching
+// ignores case.
+var Common Corpus = commonCorpus{}
+
+// Count returns 1 for a common password, and 0 otherwise
+func (commonCorpus) Count(password string) (int, error) {
+	if commonPasswords[strings.ToLower(password)] {
+		return 1, nil
+	}
+	return 0, nil
+}
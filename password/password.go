@@ -0,0 +1,109 @@
+// Package password hashes and verifies user passwords. Each hash is
+// tagged with a prefix naming the algorithm that produced it, so
+// hashes made by older algorithms or parameters can still be verified
+// while new ones are made with the current settings.
+//
+// Passwords are also peppered with a secret from a hash.Keyring. A
+// hash made with a versioned pepper is prefixed with the pepper's ID,
+// e.g. "p2$argon2id$...", so peppers can be rotated too.
+package password
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/peterpla/webdevgo/hash"
+)
+
+// ErrMismatch is returned by Verify when the password does not
+// match the hash
+var ErrMismatch = errors.New("password: password does not match hash")
+
+// ErrUnknownScheme is returned by Verify when no configured scheme
+// recognizes the hash's prefix
+var ErrUnknownScheme = errors.New("password: hash has an unknown scheme")
+
+// ErrUnknownPepper is returned by Verify when the hash was made with
+// a pepper that is no longer in the keyring
+var ErrUnknownPepper = errors.New("password: hash has an unknown pepper")
+
+// Scheme is a single password hashing algorithm and its parameters
+type Scheme interface {
+	// Hash returns the prefix-tagged hash of password
+	Hash(password []byte) (string, error)
+
+	// Verify returns nil if password matches the hash, ErrMismatch
+	// if it does not, or another error if the hash is malformed
+	Verify(hash string, password []byte) error
+
+	// Handles reports whether the hash was made by this scheme's
+	// algorithm, whatever its parameters
+	Handles(hash string) bool
+
+	// Outdated reports whether the hash was made with parameters
+	// other than the scheme's own
+	Outdated(hash string) bool
+}
+
+// Hasher hashes new passwords with its current scheme, and verifies
+// passwords against hashes made by any of its schemes. Each password
+// has a pepper from the keyring appended before hashing.
+type Hasher struct {
+	peppers *hash.Keyring
+	current Scheme
+	schemes []Scheme
+}
+
+// NewHasher returns a Hasher that hashes with current and the
+// keyring's current pepper, and can also verify hashes made by any
+// of the older schemes or peppers
+func NewHasher(peppers *hash.Keyring, current Scheme, older ...Scheme) *Hasher {
+	return &Hasher{
+		peppers: peppers,
+		current: current,
+		schemes: append([]Scheme{current}, older...),
+	}
+}
+
+// Hash returns the hash of password using the current scheme and pepper
+func (h *Hasher) Hash(password string) (string, error) {
+	pepper := h.peppers.Current()
+	hashed, err := h.current.Hash(peppered(password, pepper))
+	if err != nil {
+		return "", err
+	}
+	return pepper.ID + hashed, nil
+}
+
+// Verify checks password against hashed. On success, rehash reports
+// whether the hash should be replaced with one from Hash because it
+// was made by an older scheme or pepper, or with outdated parameters.
+func (h *Hasher) Verify(hashed, password string) (rehash bool, err error) {
+	// everything before the scheme's own "$" prefix is the pepper ID
+	i := strings.Index(hashed, "$")
+	if i < 0 {
+		return false, ErrUnknownScheme
+	}
+	pepper, ok := h.peppers.Key(hashed[:i])
+	if !ok {
+		return false, ErrUnknownPepper
+	}
+	hashed = hashed[i:]
+
+	for _, s := range h.schemes {
+		if !s.Handles(hashed) {
+			continue
+		}
+		if err := s.Verify(hashed, peppered(password, pepper)); err != nil {
+			return false, err
+		}
+		return s != h.current || s.Outdated(hashed) ||
+			pepper.ID != h.peppers.Current().ID, nil
+	}
+	return false, ErrUnknownScheme
+}
+
+// peppered returns the password with the pepper appended
+func peppered(password string, pepper hash.Key) []byte {
+	return []byte(password + pepper.Secret)
+}
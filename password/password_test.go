@@ -0,0 +1,131 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/peterpla/webdevgo/hash"
+)
+
+// fastArgon2id keeps the tests quick
+var fastArgon2id = Argon2id{Time: 1, Memory: 1024, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+// peppers returns a keyring of the provided keys, current first
+func peppers(t *testing.T, keys ...hash.Key) *hash.Keyring {
+	kr, err := hash.NewKeyring(keys[0], keys[1:]...)
+	if err != nil {
+		t.Fatalf("hash.NewKeyring(): expected nil, got \"%v\"", err)
+	}
+	return kr
+}
+
+func TestHashAndVerify(t *testing.T) {
+	schemes := []Scheme{fastArgon2id, Bcrypt{Cost: bcrypt.MinCost}}
+	for _, s := range schemes {
+		h := NewHasher(peppers(t, hash.Key{Secret: "pepper"}), s)
+		hashed, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%T Hash(): expected nil, got \"%v\"", s, err)
+		}
+		if !s.Handles(hashed) {
+			t.Errorf("%T Handles(%q): expected true", s, hashed)
+		}
+
+		rehash, err := h.Verify(hashed, "correct horse")
+		if err != nil || rehash {
+			t.Errorf("%T Verify(correct): expected false, nil, got %v, \"%v\"", s, rehash, err)
+		}
+		if _, err := h.Verify(hashed, "wrong horse"); err != ErrMismatch {
+			t.Errorf("%T Verify(wrong): expected \"%v\", got \"%v\"", s, ErrMismatch, err)
+		}
+
+		// the pepper is part of the hash
+		other := NewHasher(peppers(t, hash.Key{Secret: "different"}), s)
+		if _, err := other.Verify(hashed, "correct horse"); err != ErrMismatch {
+			t.Errorf("%T Verify(other pepper): expected \"%v\", got \"%v\"", s, ErrMismatch, err)
+		}
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	old := NewHasher(peppers(t, hash.Key{Secret: "pepper"}), Bcrypt{Cost: bcrypt.MinCost})
+	bcryptHash, _ := old.Hash("correct horse")
+
+	// verified by an older scheme
+	h := NewHasher(peppers(t, hash.Key{Secret: "pepper"}), fastArgon2id, Bcrypt{Cost: bcrypt.MinCost})
+	rehash, err := h.Verify(bcryptHash, "correct horse")
+	if err != nil || !rehash {
+		t.Errorf("Verify(bcrypt hash): expected true, nil, got %v, \"%v\"", rehash, err)
+	}
+
+	// current algorithm, but outdated parameters
+	stronger := fastArgon2id
+	stronger.Time++
+	argonHash, _ := h.Hash("correct horse")
+	h = NewHasher(peppers(t, hash.Key{Secret: "pepper"}), stronger)
+	rehash, err = h.Verify(argonHash, "correct horse")
+	if err != nil || !rehash {
+		t.Errorf("Verify(outdated argon2id): expected true, nil, got %v, \"%v\"", rehash, err)
+	}
+
+	// a scheme the hasher wasn't configured with
+	if _, err := h.Verify(bcryptHash, "correct horse"); err != ErrUnknownScheme {
+		t.Errorf("Verify(unconfigured scheme): expected \"%v\", got \"%v\"", ErrUnknownScheme, err)
+	}
+}
+
+func TestArgon2idMalformed(t *testing.T) {
+	hash, _ := fastArgon2id.Hash([]byte("correct horse"))
+	parts := strings.Split(hash, "$")
+
+	for _, bad := range []string{
+		"$argon2id$",
+		strings.Replace(hash, "v=19", "v=16", 1),
+		strings.Replace(hash, parts[3], "m=x,t=1,p=1", 1),
+		strings.Join(parts[:5], "$"),
+		hash + "!",
+	} {
+		if err := fastArgon2id.Verify(bad, []byte("correct horse")); err == nil {
+			t.Errorf("Verify(%q): expected error, got nil", bad)
+		}
+	}
+}
+
+func TestPepperRotation(t *testing.T) {
+	v1 := hash.Key{ID: "v1", Secret: "old pepper"}
+	v2 := hash.Key{ID: "v2", Secret: "new pepper"}
+	untagged := hash.Key{Secret: "original pepper"}
+
+	// hashes made before peppers were versioned
+	h := NewHasher(peppers(t, untagged), fastArgon2id)
+	original, _ := h.Hash("correct horse")
+	if !strings.HasPrefix(original, "$argon2id$") {
+		t.Errorf("Hash(untagged pepper): expected no pepper ID, got %q", original)
+	}
+
+	h = NewHasher(peppers(t, v1, untagged), fastArgon2id)
+	old, _ := h.Hash("correct horse")
+	if !strings.HasPrefix(old, "v1$argon2id$") {
+		t.Errorf("Hash(v1): expected \"v1$argon2id$\" prefix, got %q", old)
+	}
+
+	h = NewHasher(peppers(t, v2, v1, untagged), fastArgon2id)
+	for _, hashed := range []string{original, old} {
+		rehash, err := h.Verify(hashed, "correct horse")
+		if err != nil || !rehash {
+			t.Errorf("Verify(%q): expected true, nil, got %v, \"%v\"", hashed, rehash, err)
+		}
+	}
+	current, _ := h.Hash("correct horse")
+	if rehash, err := h.Verify(current, "correct horse"); err != nil || rehash {
+		t.Errorf("Verify(current): expected false, nil, got %v, \"%v\"", rehash, err)
+	}
+
+	// once a pepper is retired, its hashes can't be checked
+	h = NewHasher(peppers(t, v2), fastArgon2id)
+	if _, err := h.Verify(old, "correct horse"); err != ErrUnknownPepper {
+		t.Errorf("Verify(retired pepper): expected \"%v\", got \"%v\"", ErrUnknownPepper, err)
+	}
+}
@@ -0,0 +1,193 @@
+package password
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength scores, from Score
+const (
+	ScoreVeryWeak = iota
+	ScoreWeak
+	ScoreFair
+	ScoreStrong
+	ScoreVeryStrong
+)
+
+// scoreNames describes each Score, for showing to users
+var scoreNames = []string{"very weak", "weak", "fair", "strong", "very strong"}
+
+// ScoreName describes a Score in words, e.g. "fair"
+func ScoreName(score int) string {
+	if score < ScoreVeryWeak || score > ScoreVeryStrong {
+		return "unknown"
+	}
+	return scoreNames[score]
+}
+
+// Errors returned by Policy.Check when a password is refused
+var (
+	// ErrBreached is returned for passwords found in a Corpus more
+	// often than the policy allows
+	ErrBreached = errors.New("password: password has appeared in a data breach")
+
+	// ErrPersonal is returned for passwords containing the user's
+	// email address or name
+	ErrPersonal = errors.New("password: password contains personal information")
+
+	// ErrTooWeak is returned for passwords scoring below the
+	// policy's minimum
+	ErrTooWeak = errors.New("password: password is too easy to guess")
+)
+
+// Policy decides which passwords are acceptable
+type Policy struct {
+	// Corpora are checked for breached or common passwords
+	Corpora []Corpus
+
+	// MaxBreaches is the number of times a password may have been
+	// seen in any one Corpus and still be accepted. Zero refuses any
+	// password that has been seen at all.
+	MaxBreaches int
+
+	// MinScore is the lowest acceptable Score
+	MinScore int
+
+	// MinPersonalLen is the shortest part of an email address or name
+	// that a password may not contain; shorter parts, like initials,
+	// are ignored
+	MinPersonalLen int
+}
+
+// DefaultPolicy refuses common passwords, passwords containing
+// personal information, and those scoring below ScoreFair. Add a
+// RangeDir to Corpora to refuse breached passwords too.
+func DefaultPolicy() Policy {
+	return Policy{
+		Corpora:        []Corpus{Common},
+		MaxBreaches:    0,
+		MinScore:       ScoreFair,
+		MinPersonalLen: 3,
+	}
+}
+
+// Result is the outcome of checking a password against a Policy
+type Result struct {
+	// Score is the password's strength, from ScoreVeryWeak to
+	// ScoreVeryStrong. Breached and personal passwords score
+	// ScoreVeryWeak whatever their length.
+	Score int
+
+	// Breaches is the highest count from any Corpus
+	Breaches int
+}
+
+// Check scores the password and returns the first reason it is
+// refused, if any. personal holds the user's email address, name, and
+// anything else the password must not contain. Errors from a Corpus
+// are returned as-is, with the Result so far.
+func (p Policy) Check(password string, personal ...string) (Result, error) {
+	var res Result
+	if p.containsPersonal(password, personal) {
+		return res, ErrPersonal
+	}
+
+	for _, c := range p.Corpora {
+		n, err := c.Count(password)
+		if err != nil {
+			return res, err
+		}
+		if n > res.Breaches {
+			res.Breaches = n
+		}
+	}
+	if res.Breaches > p.MaxBreaches {
+		return res, ErrBreached
+	}
+
+	res.Score = Score(password)
+	if res.Score < p.MinScore {
+		return res, ErrTooWeak
+	}
+	return res, nil
+}
+
+// containsPersonal reports whether password contains any of the
+// personal strings, or any of their words, ignoring case. Email
+// addresses are split at '@' and '.', names at spaces and hyphens.
+func (p Policy) containsPersonal(password string, personal []string) bool {
+	lower := strings.ToLower(password)
+	for _, s := range personal {
+		words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return r == '@' || r == '.' || r == '-' || r == '_' || r == '+' || unicode.IsSpace(r)
+		})
+		for _, w := range words {
+			if len(w) >= p.MinPersonalLen && strings.Contains(lower, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Score estimates how hard the password is to guess, from
+// ScoreVeryWeak to ScoreVeryStrong. The estimate is the entropy of a
+// random password of the same length drawn from the same classes of
+// characters, discounting characters that repeat or continue a
+// sequence (e.g. "aaa", "123", "cba") from the one before.
+func Score(password string) int {
+	var lower, upper, digit, symbol, other bool
+	var length float64
+	var prev rune
+	for i, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+
+		d := r - prev
+		if i > 0 && d >= -1 && d <= 1 {
+			length += 0.5
+		} else {
+			length++
+		}
+		prev = r
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return ScoreVeryWeak
+	}
+
+	bits := length * math.Log2(float64(pool))
+	switch {
+	case bits < 28:
+		return ScoreVeryWeak
+	case bits < 36:
+		return ScoreWeak
+	case bits < 60:
+		return ScoreFair
+	case bits < 80:
+		return ScoreStrong
+	default:
+		return ScoreVeryStrong
+	}
+}
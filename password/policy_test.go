@@ -0,0 +1,86 @@
+package password
+
+import (
+	"testing"
+)
+
+func TestRangeDir(t *testing.T) {
+	corpus := RangeDir("testdata/breached")
+
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"correct horse battery staple", 1234},
+		{"Tr0ub4dor&3", 5},
+		{"tr0ub4dor&3", 0}, // hashes are case-sensitive
+		{"never breached, no range file", 0},
+	}
+	for _, tt := range tests {
+		got, err := corpus.Count(tt.password)
+		if err != nil || got != tt.want {
+			t.Errorf("Count(%q): expected %d, nil, got %d, \"%v\"", tt.password, tt.want, got, err)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := DefaultPolicy()
+	p.Corpora = append(p.Corpora, RangeDir("testdata/breached"))
+
+	personal := []string{"bozo.clown@circus.net", "Bozo T. Clown"}
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"PASSWORD123", ErrBreached}, // common, whatever the case
+		{"correct horse battery staple", ErrBreached},
+		{"myCircusPassword!", ErrPersonal},
+		{"bozoRulesTheRing", ErrPersonal},
+		{"abcdefgh", ErrBreached},
+		{"aaaabbbb", ErrTooWeak},
+		{"12121212", ErrTooWeak},
+		{"purple monkey dishwasher", nil},
+		{"T. is fine", nil}, // initials are too short to count
+	}
+	for _, tt := range tests {
+		if _, err := p.Check(tt.password, personal...); err != tt.want {
+			t.Errorf("Check(%q): expected \"%v\", got \"%v\"", tt.password, tt.want, err)
+		}
+	}
+
+	// allowing a few breaches lets rarely seen passwords through
+	p.MaxBreaches = 10
+	res, err := p.Check("Tr0ub4dor&3", personal...)
+	if err != nil || res.Breaches != 5 {
+		t.Errorf("Check(rarely breached): expected 5 breaches, nil, got %d, \"%v\"", res.Breaches, err)
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", ScoreVeryWeak},
+		{"abcdefgh", ScoreVeryWeak}, // one sequence
+		{"goodpass", ScoreWeak},
+		{"hunter22", ScoreFair},
+		{"Tr0ub4dor&3", ScoreStrong},
+		{"correct horse battery staple", ScoreVeryStrong},
+	}
+	for _, tt := range tests {
+		if got := Score(tt.password); got != tt.want {
+			t.Errorf("Score(%q): expected %d, got %d", tt.password, tt.want, got)
+		}
+	}
+}
+
+func TestScoreName(t *testing.T) {
+	if got := ScoreName(ScoreFair); got != "fair" {
+		t.Errorf("ScoreName(ScoreFair): expected \"fair\", got %q", got)
+	}
+	if got := ScoreName(ScoreVeryStrong + 1); got != "unknown" {
+		t.Errorf("ScoreName(out of range): expected \"unknown\", got %q", got)
+	}
+}
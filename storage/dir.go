@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Dir is a Store that keeps each object in a file under the named
+// directory, at the path given by its key
+type Dir string
+
+// path returns the file the object under key is kept in
+func (d Dir) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+// Put writes r to a temporary file next to the object's, then
+// renames it into place
+func (d Dir) Put(key string, r io.Reader) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get opens the object's file
+func (d Dir) Get(key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	f, err := os.Open(d.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat describes the object's file
+func (d Dir) Stat(key string) (Info, error) {
+	if !validKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	fi, err := os.Stat(d.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, err
+	}
+	if fi.IsDir() {
+		return Info{}, ErrNotFound
+	}
+	return Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Delete removes the object's file, then any directories it leaves
+// empty
+func (d Dir) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(d.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(d.path(key)); dir != filepath.Clean(string(d)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // not empty, or already gone
+		}
+	}
+	return nil
+}
+
+// List walks the directory for files whose keys begin with prefix.
+// Temporary files left by interrupted Puts are skipped.
+func (d Dir) List(prefix string) ([]Info, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+
+	// only walk the deepest directory the prefix names
+	start := string(d)
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = d.path(prefix[:i])
+	}
+
+	var infos []Info
+	err := filepath.Walk(start, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
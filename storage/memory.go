@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that keeps objects in memory, for tests. The zero
+// value is an empty Store ready to use.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memObject
+}
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Put reads r fully before storing it
+func (m *Memory) Put(key string, r io.Reader) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objects == nil {
+		m.objects = make(map[string]memObject)
+	}
+	m.objects[key] = memObject{data: data, modTime: time.Now()}
+	return nil
+}
+
+// Get returns a reader over the object's contents
+func (m *Memory) Get(key string) (io.ReadCloser, error) {
+	obj, err := m.object(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// Stat describes the object
+func (m *Memory) Stat(key string) (Info, error) {
+	obj, err := m.object(key)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+// Delete forgets the object
+func (m *Memory) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// List describes the objects whose keys begin with prefix
+func (m *Memory) List(prefix string) ([]Info, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var infos []Info
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, Info{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+// object returns the object stored under key
+func (m *Memory) object(key string) (memObject, error) {
+	if !validKey(key) {
+		return memObject{}, ErrInvalidKey
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return memObject{}, ErrNotFound
+	}
+	return obj, nil
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Timeout limits how long a request to S3 may take, including
+// reading the response body, when S3.Client isn't set, so a stalled
+// store can't hold up the handlers using it indefinitely
+const S3Timeout = 2 * time.Minute
+
+// defaultS3Client makes requests when S3.Client isn't set
+var defaultS3Client = &http.Client{Timeout: S3Timeout}
+
+// S3 is a Store that keeps objects in a bucket of an S3-compatible
+// object store, such as Amazon S3 or MinIO. Requests use path-style
+// URLs ("<Endpoint>/<Bucket>/<key>") signed with AWS Signature
+// Version 4.
+type S3 struct {
+	// Endpoint is the store's base URL, e.g.
+	// "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000"
+	Endpoint string
+
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Client makes the requests. If nil, a client with a Timeout of
+	// S3Timeout is used; a replacement should have a timeout too.
+	Client *http.Client
+}
+
+// s3Error is the body of an S3 error response
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3ListResult is the body of a ListObjectsV2 response
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// Put uploads r. S3 needs the length and hash of the body before
+// sending it, so a body that can't seek is copied to a temporary
+// file first.
+func (s *S3) Put(key string, r io.Reader) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		tmp, err := ioutil.TempFile("", "s3-put-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = tmp
+	}
+
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, body)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := s.newRequest("PUT", key, nil)
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(body)
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := s.do(req, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads the object, streaming its body to the caller
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	req, err := s.newRequest("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat describes the object from a HEAD request
+func (s *S3) Stat(key string) (Info, error) {
+	if !validKey(key) {
+		return Info{}, ErrInvalidKey
+	}
+	req, err := s.newRequest("HEAD", key, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+
+	info := Info{Key: key, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// Delete deletes the object. S3 doesn't report deleting a missing
+// object as an error either.
+func (s *S3) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	req, err := s.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List pages through ListObjectsV2 results. S3 returns keys in
+// order, so the results need no sorting.
+func (s *S3) List(prefix string) ([]Info, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	var infos []Info
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest("GET", "", query)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			infos = append(infos, Info{Key: c.Key, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return infos, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// newRequest returns an unsigned request for the object under key,
+// or for the bucket itself if key is empty
+func (s *S3) newRequest(method, key string, query url.Values) (*http.Request, error) {
+	u := strings.TrimSuffix(s.Endpoint, "/") + "/" + uriEncode(s.Bucket, true)
+	if key != "" {
+		u += "/" + uriEncode(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + strings.Replace(query.Encode(), "+", "%20", -1)
+	}
+	return http.NewRequest(method, u, nil)
+}
+
+// do signs and sends the request. Error responses are closed and
+// returned as errors, with 404s as ErrNotFound.
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	creds := credentials{
+		AccessKeyID:     s.AccessKeyID,
+		SecretAccessKey: s.SecretAccessKey,
+		Region:          s.Region,
+		Service:         "s3",
+	}
+	creds.sign(req, payloadHash, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = defaultS3Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var e s3Error
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	// HEAD responses have no body, so have no code either
+	if resp.StatusCode == http.StatusNotFound && (e.Code == "NoSuchKey" || e.Code == "") {
+		return nil, ErrNotFound
+	}
+	if e.Code == "" {
+		e.Code = strconv.Itoa(resp.StatusCode)
+	}
+	return nil, fmt.Errorf("storage: s3 %s %s: %s %s", req.Method, req.URL.Path, e.Code, e.Message)
+}
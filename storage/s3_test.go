@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+func TestSigV4(t *testing.T) {
+	// the "get-vanilla" case from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds := credentials{
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: testSecretKey,
+		Region:          "us-east-1",
+		Service:         "service",
+	}
+	creds.sign(req, emptyPayloadHash, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("sign(): expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+// s3StandIn is a minimal S3 API server for one bucket, holding its
+// objects in a Memory store. It checks every request's signature,
+// and returns at most pageSize objects per list.
+type s3StandIn struct {
+	bucket   string
+	store    Memory
+	pageSize int
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if code := s.verify(r); code != "" {
+		s.error(w, http.StatusForbidden, code)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == s.bucket && r.Method == "GET" {
+		s.list(w, r)
+		return
+	}
+	if !strings.HasPrefix(path, s.bucket+"/") {
+		s.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(path, s.bucket+"/")
+
+	switch r.Method {
+	case "PUT":
+		if r.ContentLength < 0 {
+			s.error(w, http.StatusLengthRequired, "MissingContentLength")
+			return
+		}
+		s.store.Put(key, r.Body)
+	case "GET", "HEAD":
+		info, err := s.store.Stat(key)
+		if err != nil {
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			s.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+		if r.Method == "GET" {
+			rc, _ := s.store.Get(key)
+			io.Copy(w, rc)
+			rc.Close()
+		}
+	case "DELETE":
+		s.store.Delete(key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify checks the body against its signed hash, then re-signs the
+// request and compares signatures. It returns the S3 error code for
+// the first problem found, if any.
+func (s *s3StandIn) verify(r *http.Request) string {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	sum := sha256.Sum256(body)
+	if payloadHash != hex.EncodeToString(sum[:]) {
+		return "XAmzContentSHA256Mismatch"
+	}
+
+	date, err := time.Parse(sigV4DateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "AccessDenied"
+	}
+	check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	check.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	creds := credentials{testAccessKey, testSecretKey, "us-east-1", "s3"}
+	creds.sign(check, payloadHash, date)
+	if r.Header.Get("Authorization") != check.Header.Get("Authorization") {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+// list answers ListObjectsV2, using the index of the next key as the
+// continuation token
+func (s *s3StandIn) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		s.error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	infos, _ := s.store.List(q.Get("prefix"))
+	start, _ := strconv.Atoi(q.Get("continuation-token"))
+	end := start + s.pageSize
+	if end > len(infos) {
+		end = len(infos)
+	}
+
+	var result s3ListResult
+	for _, info := range infos[start:end] {
+		result.Contents = append(result.Contents, struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		}{info.Key, info.Size, info.ModTime})
+	}
+	if end < len(infos) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		s3ListResult
+	}{s3ListResult: result})
+}
+
+func (s *s3StandIn) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		s3Error
+	}{s3Error: s3Error{Code: code, Message: code}})
+}
+
+func TestS3(t *testing.T) {
+	standIn := &s3StandIn{bucket: "photos", pageSize: 2}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	s := &S3{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "photos",
+		AccessKeyID:     testAccessKey,
+		SecretAccessKey: testSecretKey,
+	}
+	testStore(t, s)
+
+	// keys needing escaping are signed and stored as given
+	key := "galleries/7/My Photo (1)+ünï.jpg"
+	if err := s.Put(key, strings.NewReader("escaped")); err != nil {
+		t.Fatalf("Put(%q): expected nil, got \"%v\"", key, err)
+	}
+	infos, err := s.List("galleries/7/My Photo")
+	if err != nil || len(infos) != 1 || infos[0].Key != key {
+		t.Errorf("List(): expected %q, got %+v, \"%v\"", key, infos, err)
+	}
+	if _, err := standIn.store.Stat(key); err != nil {
+		t.Errorf("Put(%q): expected the stand-in to store it under that key, got \"%v\"", key, err)
+	}
+
+	// a wrong secret is refused by the server, and reported
+	bad := *s
+	bad.SecretAccessKey = "wrong"
+	if _, err := bad.Get(key); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Get(wrong secret): expected a SignatureDoesNotMatch error, got \"%v\"", err)
+	}
+}
+
+func TestS3Timeout(t *testing.T) {
+	// a store that never answers
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	s := &S3{
+		Endpoint: server.URL,
+		Region:   "us-east-1",
+		Bucket:   "photos",
+		Client:   &http.Client{Timeout: 50 * time.Millisecond},
+	}
+	start := time.Now()
+	if _, err := s.Get("galleries/7/1.jpg"); err == nil {
+		t.Errorf("Get(stalled): expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Get(stalled): expected it to give up after the timeout, took %v", elapsed)
+	}
+
+	if defaultS3Client.Timeout != S3Timeout || S3Timeout <= 0 {
+		t.Errorf("defaultS3Client: expected a timeout of %v, got %v", S3Timeout, defaultS3Client.Timeout)
+	}
+}
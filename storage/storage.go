@@ -0,0 +1,71 @@
+// Package storage stores files (objects) under slash-separated keys,
+// such as "galleries/7/12.jpg", in a local directory, in memory, or
+// in an S3-compatible object store.
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when no object is stored under a key
+	ErrNotFound = errors.New("storage: object not found")
+
+	// ErrInvalidKey is returned for keys that are empty, begin or
+	// end with a slash, or contain an empty, "." or ".." segment
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Store is a place to keep objects. Implementations are safe for
+// concurrent use.
+type Store interface {
+	// Put stores the contents of r under key, replacing any object
+	// already there. The object is either stored whole or not at all.
+	Put(key string, r io.Reader) error
+
+	// Get returns the object stored under key, or ErrNotFound. The
+	// caller must close it.
+	Get(key string) (io.ReadCloser, error)
+
+	// Stat describes the object stored under key, or returns
+	// ErrNotFound
+	Stat(key string) (Info, error)
+
+	// Delete removes the object stored under key. Deleting a missing
+	// object is not an error.
+	Delete(key string) error
+
+	// List describes every object whose key begins with prefix, in
+	// key order. The prefix must be empty, or a valid key optionally
+	// followed by a slash; otherwise ErrInvalidKey is returned.
+	List(prefix string) ([]Info, error)
+}
+
+// Info describes a stored object
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// validKey reports whether key can be used with every Store
+func validKey(key string) bool {
+	if key == "" || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// validPrefix reports whether prefix can be listed with every Store:
+// it is empty, or a valid key optionally followed by a slash
+func validPrefix(prefix string) bool {
+	return prefix == "" || validKey(strings.TrimSuffix(prefix, "/"))
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testStore checks the behaviour every Store must share
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+
+	objects := map[string]string{
+		"galleries/7/1.jpg":  "first image",
+		"galleries/7/2.png":  "second image",
+		"galleries/70/1.gif": "another gallery",
+		"top level":          "",
+	}
+	for key, content := range objects {
+		if err := s.Put(key, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put(%q): expected nil, got \"%v\"", key, err)
+		}
+	}
+	// Put replaces, and accepts readers that can't seek
+	objects["galleries/7/1.jpg"] = "replaced"
+	if err := s.Put("galleries/7/1.jpg", ioutil.NopCloser(bytes.NewBufferString("replaced"))); err != nil {
+		t.Fatalf("Put(replace): expected nil, got \"%v\"", err)
+	}
+
+	for key, content := range objects {
+		rc, err := s.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%q): expected nil, got \"%v\"", key, err)
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != content {
+			t.Errorf("Get(%q): expected %q, got %q", key, content, got)
+		}
+
+		info, err := s.Stat(key)
+		if err != nil || info.Key != key || info.Size != int64(len(content)) || info.ModTime.IsZero() {
+			t.Errorf("Stat(%q): expected %d bytes, got %+v, \"%v\"", key, len(content), info, err)
+		}
+	}
+
+	infos, err := s.List("galleries/7/")
+	if err != nil {
+		t.Fatalf("List(): expected nil, got \"%v\"", err)
+	}
+	if len(infos) != 2 || infos[0].Key != "galleries/7/1.jpg" || infos[1].Key != "galleries/7/2.png" ||
+		infos[0].Size != int64(len("replaced")) {
+		t.Errorf("List(\"galleries/7/\"): expected 1.jpg and 2.png, got %+v", infos)
+	}
+	if infos, _ := s.List("galleries/7"); len(infos) != 3 {
+		t.Errorf("List(\"galleries/7\"): expected 3 objects, got %+v", infos)
+	}
+	if infos, _ := s.List(""); len(infos) != len(objects) {
+		t.Errorf("List(\"\"): expected %d objects, got %+v", len(objects), infos)
+	}
+	if infos, err := s.List("nothing/"); err != nil || len(infos) != 0 {
+		t.Errorf("List(\"nothing/\"): expected no objects, got %+v, \"%v\"", infos, err)
+	}
+
+	if err := s.Delete("galleries/7/1.jpg"); err != nil {
+		t.Fatalf("Delete(): expected nil, got \"%v\"", err)
+	}
+	if err := s.Delete("galleries/7/1.jpg"); err != nil {
+		t.Errorf("Delete(missing): expected nil, got \"%v\"", err)
+	}
+	if _, err := s.Get("galleries/7/1.jpg"); err != ErrNotFound {
+		t.Errorf("Get(deleted): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+	if _, err := s.Stat("galleries/7/1.jpg"); err != ErrNotFound {
+		t.Errorf("Stat(deleted): expected \"%v\", got \"%v\"", ErrNotFound, err)
+	}
+
+	for _, key := range []string{"", "/abs", "trailing/", "a//b", "../escape", "a/./b", `a\b`} {
+		if err := s.Put(key, strings.NewReader("x")); err != ErrInvalidKey {
+			t.Errorf("Put(%q): expected \"%v\", got \"%v\"", key, ErrInvalidKey, err)
+		}
+		if _, err := s.Get(key); err != ErrInvalidKey {
+			t.Errorf("Get(%q): expected \"%v\", got \"%v\"", key, ErrInvalidKey, err)
+		}
+	}
+	for _, prefix := range []string{"/", "/abs", "a//b", "../", "../escape", "a/../b", "a/./", `a\b`} {
+		if _, err := s.List(prefix); err != ErrInvalidKey {
+			t.Errorf("List(%q): expected \"%v\", got \"%v\"", prefix, ErrInvalidKey, err)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("TempDir(): expected nil, got \"%v\"", err)
+	}
+	defer os.RemoveAll(root)
+
+	testStore(t, Dir(root))
+
+	// directories emptied by Delete are removed
+	Dir(root).Delete("galleries/7/2.png")
+	if _, err := os.Stat(root + "/galleries/7"); !os.IsNotExist(err) {
+		t.Errorf("Delete(): expected the empty directory to be removed, got \"%v\"", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("Delete(): expected the root to remain, got \"%v\"", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, &Memory{})
+}
@@ -0,0 +1,101 @@
+// Package totp implements RFC 6238 time-based one-time passwords,
+// as used by authenticator apps for two-factor authentication
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/peterpla/webdevgo/rand"
+)
+
+// Parameters shared with the authenticator app. These are the
+// defaults every app supports, so they are not configurable.
+const (
+	Digits = 6
+	Period = 30 // seconds
+
+	// SecretBytes is the length of a generated secret, as
+	// recommended by RFC 4226
+	SecretBytes = 20
+)
+
+// encoding is the base32 alphabet authenticator apps expect, unpadded
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a new random base32-encoded secret
+func NewSecret() (string, error) {
+	b, err := rand.Bytes(SecretBytes)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the time step containing t
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for the provided secret at the given time step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// Validate checks code against the secret at time t, allowing for
+// skew steps of clock drift either side. On success it returns the
+// matching time step, so callers can refuse to accept a code for the
+// same (or an earlier) step twice.
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		want, err := Code(secret, now+i)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return now + i, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// provisioning URI for the secret. Encoded
+// as a QR code (or followed on a phone), it adds the account to an
+// authenticator app.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits
+func TestCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	type testset struct {
+		unix int64
+		code string
+	}
+
+	var tests = []testset{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, r := range tests {
+		code, err := Code(secret, Step(time.Unix(r.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): expected nil, got \"%v\"", r.unix, err)
+		}
+		if code != r.code {
+			t.Errorf("Code(%d): got %s, want %s", r.unix, code, r.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret(): expected nil, got \"%v\"", err)
+	}
+	now := time.Now()
+	code, _ := Code(secret, Step(now))
+	prev, _ := Code(secret, Step(now)-1)
+	old, _ := Code(secret, Step(now)-5)
+
+	if step, ok := Validate(secret, code, now, 1); !ok || step != Step(now) {
+		t.Errorf("Validate(current): got %d %t, want %d true", step, ok, Step(now))
+	}
+	if _, ok := Validate(secret, prev, now, 1); !ok {
+		t.Errorf("Validate(previous step): expected to be accepted within skew")
+	}
+	if _, ok := Validate(secret, old, now, 1); ok && old != code {
+		t.Errorf("Validate(5 steps old): expected to be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Errorf("Validate(short code): expected to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Whatever.com", "bozo@clown.net", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Whatever.com:bozo@clown.net?") {
+		t.Errorf("URI: unexpected label in %q", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Whatever.com") {
+		t.Errorf("URI: missing secret or issuer in %q", uri)
+	}
+}
@@ -1,11 +1,16 @@
 package views
 
-import "log"
+import (
+	"log"
+
+	"github.com/peterpla/webdevgo/models"
+)
 
 // Data is the top-level structure that views expect data
 // to come in
 type Data struct {
 	Alert *Alert
+	User  *models.User
 	Yield interface{}
 }
 
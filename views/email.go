@@ -0,0 +1,68 @@
+package views
+
+import (
+	"bytes"
+	"html/template"
+	"path/filepath"
+	texttemplate "text/template"
+)
+
+// EmailLayout is the layout used to render the HTML part of every email,
+// so mail shares the site's branding (see layouts/email.gohtml)
+var EmailLayout = "email"
+
+// EmailTextLayout is the layout used to render the plain text part
+// of every email (see layouts/email_text.txt)
+var EmailTextLayout = "email_text"
+
+// EmailTextExt sets the file extension for plain text email templates
+var EmailTextExt = ".txt"
+
+// EmailView renders the HTML and plain text bodies of one kind of email
+type EmailView struct {
+	HTML *template.Template
+	Text *texttemplate.Template
+}
+
+// NewEmailView creates a new EmailView from the named template, e.g.
+// "emails/reset_pw" parses emails/reset_pw.gohtml along with the site
+// layouts for the HTML body, and emails/reset_pw.txt along with the
+// text layout for the plain text body. Both define a "yield" template.
+func NewEmailView(name string) *EmailView {
+	htmlFiles := []string{name}
+	addTemplatePath(htmlFiles)
+	addTemplateExt(htmlFiles)
+	htmlFiles = append(htmlFiles, layoutFiles()...)
+
+	h, err := template.New("").Funcs(templateFuncs()).ParseFiles(htmlFiles...)
+	if err != nil {
+		panic(err)
+	}
+
+	textFiles := []string{
+		TemplateDir + name + EmailTextExt,
+		filepath.Join(LayoutDir, EmailTextLayout+EmailTextExt),
+	}
+	t, err := texttemplate.ParseFiles(textFiles...)
+	if err != nil {
+		panic(err)
+	}
+
+	return &EmailView{
+		HTML: h,
+		Text: t,
+	}
+}
+
+// Render executes both templates with data, returning the HTML
+// and plain text bodies
+func (ev *EmailView) Render(data interface{}) (html string, text string, err error) {
+	var hbuf, tbuf bytes.Buffer
+	if err := ev.HTML.ExecuteTemplate(&hbuf, EmailLayout, data); err != nil {
+		return "", "", err
+	}
+	if err := ev.Text.ExecuteTemplate(&tbuf, EmailTextLayout, data); err != nil {
+		return "", "", err
+	}
+	return hbuf.String(), tbuf.String(), nil
+}
@@ -2,10 +2,15 @@ package views
 
 import (
 	"bytes"
+	"errors"
 	"html/template"
 	"io"
 	"net/http"
 	"path/filepath"
+
+	"github.com/peterpla/webdevgo/context"
+	"github.com/peterpla/webdevgo/middleware"
+	"github.com/peterpla/webdevgo/models"
 )
 
 // LayoutDir sets the path to layout files
@@ -17,40 +22,96 @@ var TemplateDir = "views/"
 // TemplateExt sets the file extension for template files
 var TemplateExt = ".gohtml"
 
+// ImageURL returns the signed URL of an image transformed on demand,
+// for the imageURL template function (see controllers.Images.URL).
+// It is set when the app starts.
+var ImageURL func(image *models.Image, width, height int, fit, format string) string
+
 // View struct used by most view methods
 type View struct {
 	Template *template.Template
 	Layout   string
 }
 
-// Render method used to render templates into web pages
-func (v *View) Render(w http.ResponseWriter, data interface{}) {
+// Render method used to render templates into web pages. The signed-in
+// user (if any) is pulled from the request context so layouts can
+// adapt, e.g. the navbar showing "Log Out" instead of "Log In"
+func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{}) {
+	v.RenderStatus(w, r, http.StatusOK, data)
+}
+
+// RenderStatus renders the page as Render does, with the provided
+// HTTP status code, e.g. for error pages
+func (v *View) RenderStatus(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
 	w.Header().Set("Content-Type", "text/html")
 
-	switch data.(type) {
+	var vd Data
+	switch d := data.(type) {
 	case Data:
-		// Data struct - do nothing, View processing this
+		// Data struct - use it as-is
+		vd = d
 	default:
 		// not a Data struct - pass the data argument in a Data struct
-		data = Data{
+		vd = Data{
 			Yield: data,
 		}
 	}
-
-	var buf bytes.Buffer
-	err := v.Template.ExecuteTemplate(&buf, v.Layout, data)
-	if err != nil {
-		http.Error(w, "Something went wrong. If the problem persists, please email support@exercise.com",
-			http.StatusInternalServerError)
-		return
+	vd.User = context.User(r.Context())
+
+	// the template functions depend on the request, so are added
+	// to a copy of the template to keep concurrent renders apart
+	tpl, err := v.Template.Clone()
+	if err == nil {
+		tpl.Funcs(template.FuncMap{
+			"csrfField": func() template.HTML {
+				return csrfField(r)
+			},
+		})
+
+		var buf bytes.Buffer
+		err = tpl.ExecuteTemplate(&buf, v.Layout, vd)
+		if err == nil {
+			// template executed without error, copy the buffer to w, and done
+			w.WriteHeader(status)
+			io.Copy(w, &buf)
+			return
+		}
 	}
-
-	// template executed without error, copy the buffer to w, and done
-	io.Copy(w, &buf)
+	http.Error(w, "Something went wrong. If the problem persists, please email support@exercise.com",
+		http.StatusInternalServerError)
 }
 
 func (v *View) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	v.Render(w, nil)
+	v.Render(w, r, nil)
+}
+
+// templateFuncs are the functions available to every template. Those
+// that depend on the request are placeholders here, replaced when
+// the view is rendered.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"csrfField": func() (template.HTML, error) {
+			return "", errors.New("views: csrfField is only available when rendering a View")
+		},
+		"imageURL": func(image *models.Image, width, height int, fit, format string) (string, error) {
+			if ImageURL == nil {
+				return "", errors.New("views: imageURL needs views.ImageURL to be set")
+			}
+			return ImageURL(image, width, height, fit, format), nil
+		},
+	}
+}
+
+// csrfField returns a hidden form field holding the request's
+// anti-forgery token, to be included in every POST form. It is empty
+// if the request didn't pass through the CSRF middleware.
+func csrfField(r *http.Request) template.HTML {
+	token := context.CSRFToken(r.Context())
+	if token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + middleware.CSRFField +
+		`" value="` + template.HTMLEscapeString(token) + `">`)
 }
 
 func layoutFiles() []string {
@@ -67,7 +128,7 @@ func NewView(layout string, files ...string) *View {
 	addTemplateExt(files)
 	files = append(files, layoutFiles()...)
 
-	t, err := template.ParseFiles(files...)
+	t, err := template.New("").Funcs(templateFuncs()).ParseFiles(files...)
 	if err != nil {
 		panic(err)
 	}